@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"strings"
+)
+
+// APIKeyPrefix starts every personal API key, so keys can be recognized (e.g. by
+// secret scanners) and told apart from JWTs
+const APIKeyPrefix = "ak_"
+
+// apiKeyDisplayLength is how many characters of a key are kept in clear to
+// identify it in listings
+const apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new random API key, the prefix identifying it in
+// listings and the hash that should be persisted in its place
+func GenerateAPIKey() (key string, displayPrefix string, keyHash string, err error) {
+	secret, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key = APIKeyPrefix + secret
+	return key, key[:apiKeyDisplayLength], HashOpaqueToken(key), nil
+}
+
+// LooksLikeAPIKey reports whether the value has the format of an API key
+func LooksLikeAPIKey(value string) bool {
+	return strings.HasPrefix(value, APIKeyPrefix) && len(value) > apiKeyDisplayLength
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/drive-deep/auth-microservices/config"
+)
+
+// ErrNoEncryptionKey is returned when secrets must be encrypted but KEY_ENCRYPTION_KEY is not set
+var ErrNoEncryptionKey = errors.New("KEY_ENCRYPTION_KEY is not set")
+
+// encryptionKey is the AES-256 key protecting secrets stored in the database.
+// KEY_ENCRYPTION_KEY holds 32 random bytes, base64 encoded.
+var encryptionKey = loadEncryptionKey()
+
+// loadEncryptionKey decodes KEY_ENCRYPTION_KEY, returning nil if it is unset or invalid
+func loadEncryptionKey() []byte {
+	key, err := base64.StdEncoding.DecodeString(config.GetEnv("KEY_ENCRYPTION_KEY", ""))
+	if err != nil || len(key) != 32 {
+		return nil
+	}
+	return key
+}
+
+// newGCM creates the AES-GCM cipher used for secrets at rest
+func newGCM() (cipher.AEAD, error) {
+	if encryptionKey == nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptSecret encrypts a secret for storage with AES-256-GCM. The result is
+// the base64 encoded nonce followed by the ciphertext. additionalData binds the
+// ciphertext to its owner (e.g. the row ID) so it cannot be swapped between rows.
+func EncryptSecret(plaintext, additionalData []byte) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret
+func DecryptSecret(encoded string, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -6,60 +6,88 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/drive-deep/auth-microservices/config"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET")) // Replace with a secure key
+var jwtSecret = []byte(config.GetEnv("JWT_SECRET", "")) // Replace with a secure key
+
+// keyFunc selects the verification key by the token's "kid" header and makes
+// sure the token's algorithm matches the key, so an RSA public key can never be
+// used as an HMAC secret.
+func keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key := DefaultKeyRing.Lookup(kid)
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+	if t.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.verifyKey(), nil
+}
 
 // GenerateToken generates a new JWT token for a given user ID and email.
+// Every token carries a unique "jti" so it can be revoked individually.
 func GenerateToken(userID, email string, expirationHours int) (string, error) {
+	return GenerateTokenWithClaims(userID, email, expirationHours, nil)
+}
+
+// GenerateTokenWithClaims generates a new JWT token like GenerateToken, adding
+// extra claims (scope, client_id, ...). Extra claims cannot override the
+// registered claims set by this function.
+func GenerateTokenWithClaims(userID, email string, expirationHours int, extra map[string]interface{}) (string, error) {
 	// Create a new token with the specified claims
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"email":   email,
-		"exp":     time.Now().Add(time.Hour * time.Duration(expirationHours)).Unix(),
+	claims := jwt.MapClaims{}
+	for name, value := range extra {
+		claims[name] = value
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString(jwtSecret)
+	now := time.Now()
+	claims["jti"] = uuid.New().String()
+	claims["token_use"] = TokenUseAccess
+	claims["iss"] = Issuer
+	claims["sub"] = userID
+	claims["user_id"] = userID
+	claims["email"] = email
+	claims["iat"] = numericDate(now) // Compared with the revocation watermark
+	claims["exp"] = now.Add(time.Hour * time.Duration(expirationHours)).Unix()
+
+	// Sign the token with the active signing key
+	tokenString, err := DefaultKeyRing.Active().Sign(claims)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-// RefreshToken generates a new token by extending the expiration of an existing valid token
-func RefreshToken(oldTokenString string, newExpirationHours int) (string, error) {
-	// Parse the old token without validating the expiration (since it might be expired)
-	token, err := jwt.Parse(oldTokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
-
-	// Check if parsing and validation were successful
-	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
+// GenerateServiceToken generates a JWT for a client acting on its own behalf
+// (client credentials grant). Unlike user tokens it has no "user_id" or
+// "email"; "sub" and "client_id" both hold the client ID.
+func GenerateServiceToken(clientID, audience, scope string, expirationHours int) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":       uuid.New().String(),
+		"token_use": TokenUseAccess,
+		"iss":       Issuer,
+		"sub":       clientID,
+		"client_id": clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(time.Hour * time.Duration(expirationHours)).Unix(),
 	}
-
-	// Extract claims and create a new token with updated expiration
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", errors.New("could not parse token claims")
+	if audience != "" {
+		claims["aud"] = audience
+	}
+	if scope != "" {
+		claims["scope"] = scope
 	}
 
-	// Set a new expiration time
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(newExpirationHours)).Unix()
-
-	// Create a new token with the updated claims
-	newToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return newToken.SignedString(jwtSecret)
+	return DefaultKeyRing.Active().Sign(claims)
 }
 
 // generateSalt generates a random 16-byte salt
@@ -72,8 +100,10 @@ func GenerateSalt() (string, error) {
 	return hex.EncodeToString(salt), nil
 }
 
-// HashPasswordWithSalt hashes the password with the provided salt and returns the hashed password
-// HashPasswordWithFixedSalt hashes the password with a fixed salt and returns the hashed password
+// HashPasswordWithSalt hashes the password with the provided salt and returns the hashed password.
+//
+// Deprecated: this is the legacy single round SHA-256 scheme, kept only so
+// VerifyPassword can check (and upgrade) old hashes. Use HashPassword instead.
 func HashPasswordWithSalt(password, salt string) (string, error) {
 	// Trim any leading or trailing whitespace from both the password and fixed salt
 	password = strings.TrimSpace(password)
@@ -97,18 +127,35 @@ func HashPasswordWithSalt(password, salt string) (string, error) {
 	return hashedPassword, nil
 }
 
+// Values of the "token_use" claim. Tokens without the claim predate it and are
+// access tokens.
+const (
+	TokenUseAccess = "access"
+	TokenUseID     = "id"
+)
+
 // ValidateToken validates a JWT token string by checking its expiration and signature.
-// It returns the claims if valid or an error if invalid.
+// It returns the claims if valid or an error if invalid. Only access tokens are
+// accepted; ID tokens and purpose tokens signed with the same keys are rejected.
 func ValidateToken(tokenString string) (*jwt.MapClaims, error) {
+	claims, err := parseSignedToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if use, ok := claims["token_use"]; ok && use != TokenUseAccess {
+		return nil, errors.New("invalid token type")
+	}
+
+	// Return the valid claims
+	return &claims, nil
+}
+
+// parseSignedToken verifies the signature and expiration of any token signed
+// by this service and returns its claims
+func parseSignedToken(tokenString string) (jwt.MapClaims, error) {
 	// Parse the token and validate the claims
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		// Ensure the signing method is HMAC (HS256)
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		// Return the JWT secret key for validation
-		return jwtSecret, nil
-	})
+	token, err := jwt.Parse(tokenString, keyFunc)
 
 	// If there was an error parsing the token, return it
 	if err != nil {
@@ -132,6 +179,5 @@ func ValidateToken(tokenString string) (*jwt.MapClaims, error) {
 		return nil, errors.New("token is expired")
 	}
 
-	// Return the valid claims
-	return &claims, nil
+	return claims, nil
 }
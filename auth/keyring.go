@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyRing holds the active signing key plus retired keys that are still
+// accepted for verification until every token they signed has expired.
+type KeyRing struct {
+	mu           sync.RWMutex
+	active       *SigningKey
+	verification map[string]*SigningKey
+}
+
+// NewKeyRing creates a key ring with an active key and optional verification-only keys
+func NewKeyRing(active *SigningKey, verification ...*SigningKey) *KeyRing {
+	ring := &KeyRing{}
+	ring.Replace(active, verification)
+	return ring
+}
+
+// Replace atomically swaps the active key and the set of verification-only keys
+func (r *KeyRing) Replace(active *SigningKey, verification []*SigningKey) {
+	keys := make(map[string]*SigningKey, len(verification)+1)
+	for _, key := range verification {
+		keys[key.ID] = key
+	}
+	keys[active.ID] = active
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = active
+	r.verification = keys
+}
+
+// Active returns the key new tokens are signed with
+func (r *KeyRing) Active() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// Lookup returns the key with the given kid, or nil if it is unknown or retired
+func (r *KeyRing) Lookup(kid string) *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.verification[kid]
+}
+
+// Keys returns every key tokens may currently be verified with, active key first
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(r.verification))
+	for _, key := range r.verification {
+		if key != r.active {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return append([]*SigningKey{r.active}, keys...)
+}
+
+// MaxTokenLifetime is how long a retired key must remain available for
+// verification: the longest lifetime of any token signed with it.
+func MaxTokenLifetime() time.Duration {
+	hours := AccessTokenExpirationHours
+	if IDTokenExpirationHours > hours {
+		hours = IDTokenExpirationHours
+	}
+	return time.Hour * time.Duration(hours)
+}
+
+// envSigningKey is the signing key configured through the environment
+var envSigningKey = loadSigningKeyFromEnv()
+
+// DefaultKeyRing is used by GenerateToken and ValidateToken. It starts out with
+// the key configured in the environment and is replaced by the keys stored in
+// the database once they are loaded.
+var DefaultKeyRing = NewKeyRing(envSigningKey, legacyVerificationKeys()...)
+
+// legacyVerificationKeys returns keys that only verify tokens issued before key
+// IDs were introduced (HS256 with JWT_SECRET and no kid)
+func legacyVerificationKeys() []*SigningKey {
+	if len(jwtSecret) == 0 || envSigningKey.ID == "" {
+		return nil
+	}
+	return []*SigningKey{NewHMACSigningKey("", jwtSecret)}
+}
+
+// EnvSigningKey returns the signing key configured through the environment
+func EnvSigningKey() *SigningKey {
+	return envSigningKey
+}
+
+// EnvVerificationKeys returns the environment configured keys. They stay
+// trusted for verification after keys from the database take over signing.
+func EnvVerificationKeys() []*SigningKey {
+	if envSigningKey.Algorithm == AlgHS256 && len(jwtSecret) == 0 {
+		// Never trust an empty HMAC secret once real keys are available
+		return nil
+	}
+	return append([]*SigningKey{envSigningKey}, legacyVerificationKeys()...)
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/redis"
+)
+
+// LockoutPolicy limits password guessing. Failed logins are counted per
+// account and per account and client IP. After FreeAttempts failures from the
+// same IP every further attempt must wait an exponentially growing delay, and
+// reaching a threshold locks the account (for every IP) or the account for that
+// IP only. Counters reset after Window without failures.
+type LockoutPolicy struct {
+	Window       time.Duration // How long failures are remembered
+	FreeAttempts int           // Failures per account and IP before delays start
+	BaseDelay    time.Duration // First delay, doubled with every further failure
+	MaxDelay     time.Duration // Upper bound of the delay
+
+	IPThreshold      int // Failures per account and IP that lock the account for that IP
+	AccountThreshold int // Failures per account (any IP) that lock the account
+	LockDuration     time.Duration
+}
+
+// DefaultLockoutPolicy is configured from the environment: LOCKOUT_WINDOW_MINUTES,
+// LOCKOUT_FREE_ATTEMPTS, LOCKOUT_BASE_DELAY_SECONDS, LOCKOUT_MAX_DELAY_SECONDS,
+// LOCKOUT_IP_THRESHOLD, LOCKOUT_ACCOUNT_THRESHOLD and LOCKOUT_DURATION_MINUTES
+var DefaultLockoutPolicy = &LockoutPolicy{
+	Window:           time.Duration(config.GetEnvInt("LOCKOUT_WINDOW_MINUTES", 15)) * time.Minute,
+	FreeAttempts:     config.GetEnvInt("LOCKOUT_FREE_ATTEMPTS", 3),
+	BaseDelay:        time.Duration(config.GetEnvInt("LOCKOUT_BASE_DELAY_SECONDS", 1)) * time.Second,
+	MaxDelay:         time.Duration(config.GetEnvInt("LOCKOUT_MAX_DELAY_SECONDS", 60)) * time.Second,
+	IPThreshold:      config.GetEnvInt("LOCKOUT_IP_THRESHOLD", 10),
+	AccountThreshold: config.GetEnvInt("LOCKOUT_ACCOUNT_THRESHOLD", 25),
+	LockDuration:     time.Duration(config.GetEnvInt("LOCKOUT_DURATION_MINUTES", 15)) * time.Minute,
+}
+
+// LockoutError is returned when a login attempt is refused before the
+// password is even checked
+type LockoutError struct {
+	Locked     bool          // The account is locked, rather than delayed
+	RetryAfter time.Duration // When the next attempt will be accepted
+}
+
+// Error implements error
+func (e *LockoutError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account temporarily locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// LockStatus describes the lockout state of an account
+type LockStatus struct {
+	Locked         bool       `json:"locked"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	FailedAttempts int64      `json:"failed_attempts"` // Failures within the window, all IPs
+}
+
+// failuresKey is the Redis key counting failed logins for the account
+func failuresKey(userID string) string {
+	return "lockout:failures:" + userID
+}
+
+// failuresIPKey is the Redis key counting failed logins for the account from one IP
+func failuresIPKey(userID, ip string) string {
+	return "lockout:failures:" + userID + ":" + ip
+}
+
+// lockKey is the Redis key locking the account
+func lockKey(userID string) string {
+	return "lockout:lock:" + userID
+}
+
+// lockIPKey is the Redis key locking the account for one IP
+func lockIPKey(userID, ip string) string {
+	return "lockout:lock:" + userID + ":" + ip
+}
+
+// delayKey is the Redis key delaying the next login for the account from one IP
+func delayKey(userID, ip string) string {
+	return "lockout:delay:" + userID + ":" + ip
+}
+
+// Check returns a *LockoutError if a login attempt for the account from ip
+// must be refused right now
+func (p *LockoutPolicy) Check(ctx context.Context, client *redis.RedisClient, userID, ip string) error {
+	for _, key := range []string{lockKey(userID), lockIPKey(userID, ip)} {
+		ttl, err := client.TTL(ctx, key)
+		if err == redis.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return &LockoutError{Locked: true, RetryAfter: ttl}
+	}
+
+	ttl, err := client.TTL(ctx, delayKey(userID, ip))
+	if err == redis.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return &LockoutError{RetryAfter: ttl}
+}
+
+// RecordFailure counts a failed login and applies delays and locks. It reports
+// whether this failure locked the whole account, so the owner can be told.
+func (p *LockoutPolicy) RecordFailure(ctx context.Context, client *redis.RedisClient, userID, ip string) (bool, error) {
+	accountFailures, err := client.Incr(ctx, failuresKey(userID), p.Window)
+	if err != nil {
+		return false, err
+	}
+	ipFailures, err := client.Incr(ctx, failuresIPKey(userID, ip), p.Window)
+	if err != nil {
+		return false, err
+	}
+
+	until := time.Now().Add(p.LockDuration)
+	lockedAt := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Attempts are refused while locked, so reaching this point means a new lock
+	if accountFailures >= int64(p.AccountThreshold) {
+		if err := client.Set(ctx, lockKey(userID), lockedAt, until); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	if ipFailures >= int64(p.IPThreshold) {
+		return false, client.Set(ctx, lockIPKey(userID, ip), lockedAt, until)
+	}
+
+	if ipFailures > int64(p.FreeAttempts) {
+		return false, client.Set(ctx, delayKey(userID, ip), lockedAt, time.Now().Add(p.delay(ipFailures)))
+	}
+
+	return false, nil
+}
+
+// delay is the wait imposed after the given number of failures
+func (p *LockoutPolicy) delay(failures int64) time.Duration {
+	delay := p.BaseDelay
+	for i := int64(p.FreeAttempts) + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// RecordSuccess forgets the failures from ip after a successful login. The
+// account-wide counter is kept so a distributed attack still reaches its threshold.
+func (p *LockoutPolicy) RecordSuccess(ctx context.Context, client *redis.RedisClient, userID, ip string) error {
+	for _, key := range []string{failuresIPKey(userID, ip), delayKey(userID, ip)} {
+		if err := client.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status returns the lockout state of the account
+func (p *LockoutPolicy) Status(ctx context.Context, client *redis.RedisClient, userID string) (*LockStatus, error) {
+	status := &LockStatus{}
+
+	failures, err := client.Get(ctx, failuresKey(userID))
+	if err != nil && err != redis.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		status.FailedAttempts, _ = strconv.ParseInt(failures, 10, 64)
+	}
+
+	ttl, err := client.TTL(ctx, lockKey(userID))
+	if err != nil && err != redis.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		until := time.Now().Add(ttl).Truncate(time.Second)
+		status.Locked = true
+		status.LockedUntil = &until
+	}
+
+	return status, nil
+}
+
+// Unlock removes every lock, delay and failure counter of the account
+func (p *LockoutPolicy) Unlock(ctx context.Context, client *redis.RedisClient, userID string) error {
+	if err := client.Delete(ctx, failuresKey(userID)); err != nil {
+		return err
+	}
+	if err := client.Delete(ctx, lockKey(userID)); err != nil {
+		return err
+	}
+	return client.DeleteMatching(ctx, "lockout:*:"+userID+":*")
+}
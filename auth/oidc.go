@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Issuer identifies this service in the "iss" claim and the OpenID Connect
+// discovery document. It must be the externally reachable base URL.
+var Issuer = strings.TrimRight(config.GetEnv("ISSUER_URL", "http://localhost:8080"), "/")
+
+// IDTokenExpirationHours is the lifetime of OpenID Connect ID tokens
+var IDTokenExpirationHours = config.GetEnvInt("ID_TOKEN_TTL_HOURS", 1)
+
+// IDTokenParams holds what goes into an ID token besides the registered claims
+type IDTokenParams struct {
+	Subject     string                 // User ID
+	Audience    string                 // Client ID the token is issued to
+	Nonce       string                 // Nonce from the authorization request, if any
+	AuthTime    int64                  // Unix time the user authenticated, 0 if unknown
+	AccessToken string                 // Access token issued alongside, used for at_hash
+	Claims      map[string]interface{} // User claims granted by scope (email, name, ...)
+}
+
+// GenerateIDToken generates an OpenID Connect ID token signed with the active key
+func GenerateIDToken(params IDTokenParams) (string, error) {
+	key := DefaultKeyRing.Active()
+
+	claims := jwt.MapClaims{}
+	for name, value := range params.Claims {
+		claims[name] = value
+	}
+
+	now := time.Now()
+	claims["token_use"] = TokenUseID
+	claims["iss"] = Issuer
+	claims["sub"] = params.Subject
+	claims["aud"] = params.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour * time.Duration(IDTokenExpirationHours)).Unix()
+	if params.Nonce != "" {
+		claims["nonce"] = params.Nonce
+	}
+	if params.AuthTime > 0 {
+		claims["auth_time"] = params.AuthTime
+	}
+	if params.AccessToken != "" {
+		claims["at_hash"] = AccessTokenHash(params.AccessToken, key.Algorithm)
+	}
+
+	return key.Sign(claims)
+}
+
+// AccessTokenHash computes the at_hash claim: the base64url encoded left half
+// of the hash of the access token, using the hash of the signing algorithm
+func AccessTokenHash(accessToken, alg string) string {
+	var h hash.Hash
+	switch alg {
+	case AlgEdDSA:
+		h = sha512.New()
+	default:
+		h = sha256.New()
+	}
+
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+// HasScope reports whether the space-delimited scope string contains the scope
+func HasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
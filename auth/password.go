@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when a stored password hash is not in a recognised format
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes and verifies passwords using a specific algorithm.
+// Encoded hashes are self-describing (PHC string format) so the algorithm and
+// its cost parameters travel with the hash.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash
+	Verify(password, encodedHash string) (bool, error)
+	// NeedsRehash reports whether the encoded hash was produced by another
+	// algorithm or with weaker parameters than the hasher is configured with
+	NeedsRehash(encodedHash string) bool
+}
+
+// Argon2idParams holds the tunable cost parameters for Argon2id
+type Argon2idParams struct {
+	Memory      uint32 // Memory in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follows the OWASP recommendation for Argon2id
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher hashes passwords with Argon2id
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+// NewArgon2idHasher creates an Argon2id hasher with the given parameters
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{Params: params}
+}
+
+// Hash implements PasswordHasher
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Iterations, h.Params.Memory, h.Params.Parallelism, h.Params.KeyLength)
+
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Params.Memory, h.Params.Iterations, h.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify implements PasswordHasher
+func (h *Argon2idHasher) Verify(password, encodedHash string) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+// NeedsRehash implements PasswordHasher
+func (h *Argon2idHasher) NeedsRehash(encodedHash string) bool {
+	params, salt, _, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < h.Params.Memory ||
+		params.Iterations < h.Params.Iterations ||
+		params.Parallelism != h.Params.Parallelism ||
+		params.KeyLength < h.Params.KeyLength ||
+		uint32(len(salt)) < h.Params.SaltLength
+}
+
+// decodeArgon2idHash parses an Argon2id PHC string into its parameters, salt and key
+func decodeArgon2idHash(encodedHash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return params, nil, nil, errors.New("incompatible argon2 version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	params.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher with the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash implements PasswordHasher
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify implements PasswordHasher. bcrypt compares in constant time.
+func (h *BcryptHasher) Verify(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NeedsRehash implements PasswordHasher
+func (h *BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+// isBcryptHash reports whether the encoded hash uses one of the bcrypt prefixes
+func isBcryptHash(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+// DefaultHasher is the hasher used for new passwords. It is configured from
+// the PASSWORD_HASHER environment variable ("argon2id" or "bcrypt").
+var DefaultHasher PasswordHasher = newHasherFromEnv()
+
+// newHasherFromEnv builds the default hasher from environment variables
+func newHasherFromEnv() PasswordHasher {
+	switch strings.ToLower(config.GetEnv("PASSWORD_HASHER", "argon2id")) {
+	case "bcrypt":
+		return NewBcryptHasher(config.GetEnvInt("BCRYPT_COST", bcrypt.DefaultCost))
+	default:
+		params := DefaultArgon2idParams
+		params.Memory = uint32(config.GetEnvInt("ARGON2_MEMORY_KIB", int(params.Memory)))
+		params.Iterations = uint32(config.GetEnvInt("ARGON2_ITERATIONS", int(params.Iterations)))
+		params.Parallelism = uint8(config.GetEnvInt("ARGON2_PARALLELISM", int(params.Parallelism)))
+		return NewArgon2idHasher(params)
+	}
+}
+
+// HashPassword hashes a password with the default hasher
+func HashPassword(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+// VerifyPassword checks a password against a stored hash in any supported format.
+// salt is only used for legacy SHA-256 hashes. needsRehash is true when the
+// password matched but the stored hash should be upgraded to the default hasher.
+func VerifyPassword(password, encodedHash, salt string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		hasher := &Argon2idHasher{Params: DefaultArgon2idParams}
+		ok, err = hasher.Verify(password, encodedHash)
+	case isBcryptHash(encodedHash):
+		hasher := &BcryptHasher{Cost: bcrypt.DefaultCost}
+		ok, err = hasher.Verify(password, encodedHash)
+	case !strings.HasPrefix(encodedHash, "$"):
+		// Legacy hex encoded SHA-256(password + salt)
+		var legacyHash string
+		legacyHash, err = HashPasswordWithSalt(password, salt)
+		if err == nil {
+			ok = subtle.ConstantTimeCompare([]byte(legacyHash), []byte(encodedHash)) == 1
+		}
+		if ok {
+			return true, true, nil
+		}
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	return true, DefaultHasher.NeedsRehash(encodedHash), nil
+}
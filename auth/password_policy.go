@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/drive-deep/auth-microservices/config"
+)
+
+// Password policy rules reported in PolicyViolation.Rule
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleLowercase    = "lowercase"
+	RuleUppercase    = "uppercase"
+	RuleDigit        = "digit"
+	RuleSymbol       = "symbol"
+	RulePersonalInfo = "personal_info"
+	RuleBreached     = "breached"
+)
+
+// PolicyViolation is a password policy rule the password does not satisfy
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy decides which passwords users may choose
+type PasswordPolicy struct {
+	MinLength int // Minimum number of characters
+	MaxLength int // Maximum number of characters, 0 for no limit
+
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+
+	// RejectPersonalInfo rejects passwords containing the user's email
+	// address, its local part or their name
+	RejectPersonalInfo bool
+
+	// Breached rejects passwords found in a breached password corpus, nil disables the check
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy is configured from the environment:
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH, PASSWORD_REQUIRE (comma-separated
+// character classes: lowercase, uppercase, digit, symbol),
+// PASSWORD_REJECT_PERSONAL_INFO and PASSWORD_BREACHED_DIR
+var DefaultPasswordPolicy = passwordPolicyFromEnv()
+
+// passwordPolicyFromEnv builds the password policy from the environment
+func passwordPolicyFromEnv() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:          config.GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:          config.GetEnvInt("PASSWORD_MAX_LENGTH", 128),
+		RejectPersonalInfo: config.GetEnv("PASSWORD_REJECT_PERSONAL_INFO", "true") == "true",
+	}
+
+	for _, class := range strings.Split(config.GetEnv("PASSWORD_REQUIRE", ""), ",") {
+		switch strings.TrimSpace(strings.ToLower(class)) {
+		case RuleLowercase:
+			policy.RequireLowercase = true
+		case RuleUppercase:
+			policy.RequireUppercase = true
+		case RuleDigit:
+			policy.RequireDigit = true
+		case RuleSymbol:
+			policy.RequireSymbol = true
+		}
+	}
+
+	if dir := config.GetEnv("PASSWORD_BREACHED_DIR", ""); dir != "" {
+		policy.Breached = NewBreachedPasswords(dir, config.GetEnvInt("PASSWORD_BREACHED_MIN_COUNT", 1))
+	}
+
+	return policy
+}
+
+// Check returns the rules the password violates, or nothing if it is
+// acceptable. personal holds the user's email address and names.
+func (p *PasswordPolicy) Check(password string, personal ...string) ([]PolicyViolation, error) {
+	var violations []PolicyViolation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PolicyViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violate(RuleMinLength, "Password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violate(RuleMaxLength, "Password must be at most %d characters long", p.MaxLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if p.RequireLowercase && !lower {
+		violate(RuleLowercase, "Password must contain a lowercase letter")
+	}
+	if p.RequireUppercase && !upper {
+		violate(RuleUppercase, "Password must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violate(RuleDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violate(RuleSymbol, "Password must contain a symbol")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personal) {
+		violate(RulePersonalInfo, "Password must not contain your email address or name")
+	}
+
+	if p.Breached != nil {
+		count, err := p.Breached.Count(password)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 && count >= p.Breached.MinCount {
+			violate(RuleBreached, "Password has appeared in a data breach, choose another one")
+		}
+	}
+
+	return violations, nil
+}
+
+// containsPersonalInfo reports whether the password contains one of the values
+// or, for email addresses, their local part. Values shorter than 3 characters
+// are ignored, they would match too many passwords.
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			candidates = append(candidates, local)
+		}
+
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= 3 && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// BreachedPasswords looks passwords up in a local copy of a breached password
+// corpus in the Have I Been Pwned range format: one file per 5 character
+// SHA-1 prefix (e.g. "21BD1" or "21BD1.txt") with "SUFFIX:COUNT" lines. Only
+// the file for the password's prefix is read, the corpus is never loaded as a
+// whole.
+type BreachedPasswords struct {
+	Dir      string
+	MinCount int // Occurrences from which a password counts as breached
+}
+
+// NewBreachedPasswords creates a lookup in the corpus stored in dir
+func NewBreachedPasswords(dir string, minCount int) *BreachedPasswords {
+	return &BreachedPasswords{Dir: dir, MinCount: minCount}
+}
+
+// Count returns how often the password appears in the corpus
+func (b *BreachedPasswords) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := b.openRange(prefix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("invalid count in breached password range %s: %v", prefix, err)
+		}
+		return n, nil
+	}
+
+	return 0, scanner.Err()
+}
+
+// openRange opens the range file of a hash prefix, with or without .txt extension
+func (b *BreachedPasswords) openRange(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(b.Dir, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	}
+	return file, err
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// violatedRules returns the rules of the violations, in order
+func violatedRules(t *testing.T, policy *PasswordPolicy, password string, personal ...string) []string {
+	t.Helper()
+
+	violations, err := policy.Check(password, personal...)
+	if err != nil {
+		t.Fatalf("Check(%q): %v", password, err)
+	}
+	var rules []string
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestPasswordPolicyLength(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 16}
+
+	tests := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{"empty", "", []string{RuleMinLength}},
+		{"too short", "abcdefg", []string{RuleMinLength}},
+		{"minimum", "abcdefgh", nil},
+		{"maximum", strings.Repeat("a", 16), nil},
+		{"too long", strings.Repeat("a", 17), []string{RuleMaxLength}},
+		// Length is counted in characters, not bytes
+		{"multibyte too short", "ééééééé", []string{RuleMinLength}},
+		{"multibyte", "éééééééé", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rules := violatedRules(t, policy, tt.password); !reflect.DeepEqual(rules, tt.rules) {
+				t.Errorf("rules = %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+
+	unlimited := &PasswordPolicy{MinLength: 8}
+	if rules := violatedRules(t, unlimited, strings.Repeat("a", 1000)); rules != nil {
+		t.Errorf("without a maximum length: rules = %v, want none", rules)
+	}
+}
+
+func TestPasswordPolicyCharacterClasses(t *testing.T) {
+	policy := &PasswordPolicy{RequireLowercase: true, RequireUppercase: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"Abcdef1!", nil},
+		{"ABCDEF1!", []string{RuleLowercase}},
+		{"abcdef1!", []string{RuleUppercase}},
+		{"Abcdefg!", []string{RuleDigit}},
+		{"Abcdefg1", []string{RuleSymbol}},
+		{"abcdefgh", []string{RuleUppercase, RuleDigit, RuleSymbol}},
+	}
+
+	for _, tt := range tests {
+		if rules := violatedRules(t, policy, tt.password); !reflect.DeepEqual(rules, tt.rules) {
+			t.Errorf("%q: rules = %v, want %v", tt.password, rules, tt.rules)
+		}
+	}
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	policy := &PasswordPolicy{RejectPersonalInfo: true}
+	personal := []string{"Jane.Doe@Example.com", "Jane", "Doe", "Al"}
+
+	tests := []struct {
+		name     string
+		password string
+		rejected bool
+	}{
+		{"email address", "jane.doe@example.com!", true},
+		{"local part", "xxJANE.DOExx", true},
+		{"first name", "iamjane2024", true},
+		{"last name", "john-doe-1", true},
+		// Values shorter than 3 characters would reject too many passwords
+		{"short name", "always open", false},
+		{"unrelated", "correct horse battery staple", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := violatedRules(t, policy, tt.password, personal...)
+			if rejected := reflect.DeepEqual(rules, []string{RulePersonalInfo}); rejected != tt.rejected || (!tt.rejected && rules != nil) {
+				t.Errorf("rules = %v, want rejected = %v", rules, tt.rejected)
+			}
+		})
+	}
+
+	policy.RejectPersonalInfo = false
+	if rules := violatedRules(t, policy, "iamjane2024", personal...); rules != nil {
+		t.Errorf("check disabled: rules = %v, want none", rules)
+	}
+}
+
+// writeRange writes a breached password range file in the Have I Been Pwned format
+func writeRange(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatalf("write range %s: %v", name, err)
+	}
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	writeRange(t, dir, "5BAA6",
+		"1D2AC6B5D8A2F1C4E93B5E1F0C9A8B7D6E5F4:3",
+		"1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824",
+		"1F0E7C4A1B2D3E4F5A6B7C8D9E0F1A2B3C4:1",
+	)
+	// SHA-1("Tr0ub4dor&3") = 874572E7A5AE6A49466A6AC578B98ADBA78C6AA6, with
+	// the .txt extension and a lowercase suffix
+	writeRange(t, dir, "87457.txt", "2e7a5ae6a49466a6ac578b98adba78c6aa6:2")
+	// The range of SHA-1("password1") = E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D,
+	// without it
+	writeRange(t, dir, "E38AD", "00A1B2C3D4E5F60718293A4B5C6D7E8F901:12")
+
+	tests := []struct {
+		name     string
+		password string
+		minCount int
+		breached bool
+	}{
+		{"hit", "password", 1, true},
+		{"hit in .txt range", "Tr0ub4dor&3", 1, true},
+		{"miss in existing range", "password1", 1, false},
+		{"miss without range file", "correct horse battery staple", 1, false},
+		{"below minimum count", "Tr0ub4dor&3", 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &PasswordPolicy{Breached: NewBreachedPasswords(dir, tt.minCount)}
+			rules := violatedRules(t, policy, tt.password)
+			if breached := reflect.DeepEqual(rules, []string{RuleBreached}); breached != tt.breached || (!tt.breached && rules != nil) {
+				t.Errorf("rules = %v, want breached = %v", rules, tt.breached)
+			}
+		})
+	}
+
+	t.Run("malformed count", func(t *testing.T) {
+		writeRange(t, dir, "5BAA6", "1E4C9B93F3F0682250B6CF8331B7EE68FD8:many")
+		policy := &PasswordPolicy{Breached: NewBreachedPasswords(dir, 1)}
+		if _, err := policy.Check("password"); err == nil {
+			t.Error("Check = nil error, want an error for the malformed range")
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keeps the tests fast; the format is the same as with the defaults
+var testArgon2idParams = Argon2idParams{
+	Memory:      8 * 1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2idHasher(testArgon2idParams)
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Errorf("hash = %q, want the PHC string format", hash)
+	}
+
+	other, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if other == hash {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+
+	if ok, err := hasher.Verify("correct horse", hash); err != nil || !ok {
+		t.Errorf("Verify(right password) = %v, %v", ok, err)
+	}
+	if ok, err := hasher.Verify("wrong horse", hash); err != nil || ok {
+		t.Errorf("Verify(wrong password) = %v, %v", ok, err)
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Error("NeedsRehash = true for a hash with the hasher's own parameters")
+	}
+	stronger := testArgon2idParams
+	stronger.Iterations = 2
+	if !NewArgon2idHasher(stronger).NeedsRehash(hash) {
+		t.Error("NeedsRehash = false for a hash with fewer iterations")
+	}
+}
+
+func TestVerifyPasswordBcrypt(t *testing.T) {
+	hash, err := NewBcryptHasher(bcrypt.MinCost).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	// Hashes from other bcrypt implementations use the $2b$ and $2y$ prefixes
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		encoded := prefix + strings.TrimPrefix(hash, "$2a$")
+
+		ok, needsRehash, err := VerifyPassword("correct horse", encoded, "")
+		if err != nil || !ok {
+			t.Errorf("%s: VerifyPassword(right password) = %v, %v", prefix, ok, err)
+		}
+		// The default hasher is Argon2id, so bcrypt hashes are upgraded
+		if !needsRehash {
+			t.Errorf("%s: needsRehash = false, want true", prefix)
+		}
+
+		ok, needsRehash, err = VerifyPassword("wrong horse", encoded, "")
+		if err != nil || ok || needsRehash {
+			t.Errorf("%s: VerifyPassword(wrong password) = %v, %v, %v", prefix, ok, needsRehash, err)
+		}
+	}
+}
+
+func TestVerifyPasswordLegacySHA256(t *testing.T) {
+	// Hex encoded SHA-256("hunter2-legacy" + "c0ffee")
+	const hash = "091da3a520bea62a7a25c7cfbcac042b8c9bf25afc31293e1b01468101cbed24"
+
+	ok, needsRehash, err := VerifyPassword("hunter2-legacy", hash, "c0ffee")
+	if err != nil || !ok || !needsRehash {
+		t.Errorf("VerifyPassword(right password) = %v, %v, %v, want true, true, nil", ok, needsRehash, err)
+	}
+
+	ok, needsRehash, err = VerifyPassword("hunter2", hash, "c0ffee")
+	if err != nil || ok || needsRehash {
+		t.Errorf("VerifyPassword(wrong password) = %v, %v, %v, want false, false, nil", ok, needsRehash, err)
+	}
+
+	ok, _, err = VerifyPassword("hunter2-legacy", hash, "decaf")
+	if err != nil || ok {
+		t.Errorf("VerifyPassword(wrong salt) = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestVerifyPasswordMalformedHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"unknown algorithm", "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA"},
+		{"missing key", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"bad version", "$argon2id$v=abc$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA"},
+		{"bad parameters", "$argon2id$v=19$m=lots,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA"},
+		{"bad salt encoding", "$argon2id$v=19$m=65536,t=3,p=2$not*base64$aGFzaA"},
+		{"bad key encoding", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$not*base64"},
+		{"truncated bcrypt", "$2a$10$tooshort"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := VerifyPassword("correct horse", tt.hash, "")
+			if err == nil || ok || needsRehash {
+				t.Errorf("VerifyPassword = %v, %v, %v, want an error", ok, needsRehash, err)
+			}
+		})
+	}
+
+	if _, _, err := VerifyPassword("correct horse", "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", ""); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Errorf("unknown algorithm: err = %v, want ErrUnknownHashFormat", err)
+	}
+
+	// An incompatible Argon2 version must not be verified with the current one
+	if _, _, err := VerifyPassword("correct horse", "$argon2id$v=16$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA", ""); err == nil {
+		t.Error("argon2 version 16: err = nil, want an error")
+	}
+}
+
+func TestNewHasherFromEnv(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		for _, name := range []string{"PASSWORD_HASHER", "ARGON2_MEMORY_KIB", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM"} {
+			t.Setenv(name, "")
+		}
+		hasher, ok := newHasherFromEnv().(*Argon2idHasher)
+		if !ok || hasher.Params != DefaultArgon2idParams {
+			t.Errorf("hasher = %#v, want Argon2id with the default parameters", hasher)
+		}
+	})
+
+	t.Run("argon2id", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASHER", "argon2id")
+		t.Setenv("ARGON2_MEMORY_KIB", "16384")
+		t.Setenv("ARGON2_ITERATIONS", "4")
+		t.Setenv("ARGON2_PARALLELISM", "1")
+		hasher, ok := newHasherFromEnv().(*Argon2idHasher)
+		if !ok {
+			t.Fatalf("hasher = %T, want *Argon2idHasher", newHasherFromEnv())
+		}
+		if p := hasher.Params; p.Memory != 16384 || p.Iterations != 4 || p.Parallelism != 1 {
+			t.Errorf("params = %+v, want m=16384, t=4, p=1", p)
+		}
+	})
+
+	t.Run("bcrypt", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASHER", "BCrypt")
+		t.Setenv("BCRYPT_COST", "12")
+		hasher, ok := newHasherFromEnv().(*BcryptHasher)
+		if !ok || hasher.Cost != 12 {
+			t.Errorf("hasher = %#v, want bcrypt with cost 12", hasher)
+		}
+	})
+
+	t.Run("bcrypt default cost", func(t *testing.T) {
+		t.Setenv("PASSWORD_HASHER", "bcrypt")
+		t.Setenv("BCRYPT_COST", "")
+		hasher, ok := newHasherFromEnv().(*BcryptHasher)
+		if !ok || hasher.Cost != bcrypt.DefaultCost {
+			t.Errorf("hasher = %#v, want bcrypt with the default cost", hasher)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// PKCEMethodS256 is the only supported PKCE code challenge method
+const PKCEMethodS256 = "S256"
+
+// pkceVerifierPattern matches a valid code verifier (RFC 7636 section 4.1)
+var pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// pkceChallengePattern matches an S256 code challenge: an unpadded base64url
+// SHA-256 digest (RFC 7636 section 4.2)
+var pkceChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+
+// PKCEChallenge computes the S256 code challenge for a code verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidPKCEChallenge reports whether challenge can be an S256 code challenge
+func ValidPKCEChallenge(challenge string) bool {
+	return pkceChallengePattern.MatchString(challenge)
+}
+
+// VerifyPKCE checks a code verifier against the S256 challenge sent with the
+// authorization request
+func VerifyPKCE(verifier, challenge string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Purposes of single-use tokens sent to users (links in emails etc.)
+const (
+	PurposeVerifyEmail  = "verify_email"
+	PurposeMFAChallenge = "mfa_challenge"
+	PurposeOrgInvite    = "org_invite"
+)
+
+// GeneratePurposeToken generates a signed token that is only valid for the given
+// purpose (its "token_use"), so it can never be used as an access token. The
+// returned jti lets the caller record the token to make it single-use.
+func GeneratePurposeToken(purpose, subject string, extra map[string]interface{}, ttl time.Duration) (token string, jti string, err error) {
+	claims := jwt.MapClaims{}
+	for name, value := range extra {
+		claims[name] = value
+	}
+
+	now := time.Now()
+	jti = uuid.New().String()
+	claims["jti"] = jti
+	claims["token_use"] = purpose
+	claims["iss"] = Issuer
+	claims["sub"] = subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	token, err = DefaultKeyRing.Active().Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, jti, nil
+}
+
+// ValidatePurposeToken validates a token generated by GeneratePurposeToken for the given purpose
+func ValidatePurposeToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	claims, err := parseSignedToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["token_use"] != purpose {
+		return nil, errors.New("invalid token type")
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return nil, errors.New("token has no jti claim")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims of user access tokens carrying the roles and permissions the user had
+// when the token was issued, the version of those, and the organization
+// (tenant) the token is scoped to with the user's role in it
+const (
+	ClaimRoles        = "roles"
+	ClaimPermissions  = "permissions"
+	ClaimAuthzVersion = "authz_version"
+	ClaimOrgID        = "org_id"
+	ClaimOrgRole      = "org_role"
+)
+
+// ErrStaleAuthorization is returned for a token issued before the user's roles
+// or permissions changed
+var ErrStaleAuthorization = errors.New("roles or permissions changed, refresh the token")
+
+// authzVersionKey is the Redis key holding the current authorization version of a user
+func authzVersionKey(userID string) string {
+	return "authz:user:" + userID + ":version"
+}
+
+// ClaimStrings reads a claim holding a list of strings. Lists decoded from a
+// token are []interface{}, lists set before signing are []string.
+func ClaimStrings(claims jwt.MapClaims, name string) []string {
+	switch values := claims[name].(type) {
+	case []string:
+		return values
+	case []interface{}:
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// SetAuthzVersion publishes the new authorization version of a user after a
+// change of their roles or permissions. The entry outlives any token issued
+// before the change.
+func SetAuthzVersion(ctx context.Context, client *redis.RedisClient, userID string, version int64) error {
+	expiration := time.Now().Add(time.Hour * time.Duration(AccessTokenExpirationHours))
+	return client.Set(ctx, authzVersionKey(userID), strconv.FormatInt(version, 10), expiration)
+}
+
+// CheckAuthzVersion returns ErrStaleAuthorization if the token carries an
+// authorization version older than the user's current one. Tokens without the
+// claim (OAuth clients, services) carry no roles and are not checked.
+func CheckAuthzVersion(ctx context.Context, client *redis.RedisClient, claims jwt.MapClaims) error {
+	tokenVersion, ok := claims[ClaimAuthzVersion].(float64)
+	userID, _ := claims["user_id"].(string)
+	if !ok || userID == "" {
+		return nil
+	}
+
+	current, err := client.Get(ctx, authzVersionKey(userID))
+	if err == redis.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.ParseInt(current, 10, 64)
+	if err != nil {
+		return err
+	}
+	if int64(tokenVersion) < version {
+		return ErrStaleAuthorization
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+)
+
+// AccessTokenExpirationHours is the lifetime of access tokens issued at login or refresh
+var AccessTokenExpirationHours = config.GetEnvInt("ACCESS_TOKEN_TTL_HOURS", 1)
+
+// RefreshTokenTTL is the lifetime of opaque refresh tokens
+var RefreshTokenTTL = time.Duration(config.GetEnvInt("REFRESH_TOKEN_TTL_HOURS", 30*24)) * time.Hour
+
+// GenerateOpaqueToken returns a random URL-safe token with 256 bits of entropy
+// together with the hash that should be persisted in its place.
+func GenerateOpaqueToken() (token string, tokenHash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken hashes an opaque token for storage and lookup. A single
+// SHA-256 round is sufficient because the tokens are high-entropy random values.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrTokenRevoked is returned when a token has been revoked before its expiry
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// revokedTokenKey is the Redis key marking a single access token (by jti) as revoked
+func revokedTokenKey(jti string) string {
+	return "revoked:jti:" + jti
+}
+
+// legacyWatermarkLimit separates watermarks in Unix seconds (until the year
+// 5138) from watermarks in Unix microseconds (from 1973)
+const legacyWatermarkLimit = 1e11
+
+// revokedBeforeKey is the Redis key holding the per-user "tokens issued before" watermark
+func revokedBeforeKey(userID string) string {
+	return "revoked:user:" + userID + ":before"
+}
+
+// numericDate encodes t as a JWT numeric date with microsecond precision, so
+// that a token issued in the same second as a revocation can be told apart
+func numericDate(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// claimTime reads a numeric date claim (exp, iat, ...) from the claims,
+// keeping the fractional seconds of numericDate
+func claimTime(claims jwt.MapClaims, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(int64(math.Round(value * 1e6))), true
+}
+
+// RevokeToken adds the token's jti to the deny-list. The entry expires together
+// with the token, after which the token is rejected for being expired anyway.
+func RevokeToken(ctx context.Context, client *redis.RedisClient, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return errors.New("token has no jti claim")
+	}
+
+	expiration, ok := claimTime(claims, "exp")
+	if !ok || expiration.Before(time.Now()) {
+		// Already expired, nothing to revoke
+		return nil
+	}
+
+	return client.Set(ctx, revokedTokenKey(jti), "1", expiration)
+}
+
+// RevokeAllTokens revokes every access token issued to the user up to now by
+// moving the user's watermark (Unix microseconds) forward. The watermark
+// outlives any token it covers.
+func RevokeAllTokens(ctx context.Context, client *redis.RedisClient, userID string) error {
+	now := time.Now()
+	expiration := now.Add(time.Hour * time.Duration(AccessTokenExpirationHours))
+
+	return client.Set(ctx, revokedBeforeKey(userID), strconv.FormatInt(now.UnixMicro(), 10), expiration)
+}
+
+// CheckRevoked returns ErrTokenRevoked if the token's jti is on the deny-list or
+// the token was issued before the user's revocation watermark.
+func CheckRevoked(ctx context.Context, client *redis.RedisClient, claims jwt.MapClaims) error {
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := client.Exists(ctx, revokedTokenKey(jti))
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	userID, _ := claims["user_id"].(string)
+	if userID == "" {
+		return nil
+	}
+
+	watermark, err := client.Get(ctx, revokedBeforeKey(userID))
+	if err == redis.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	revokedBefore, err := strconv.ParseInt(watermark, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid revocation watermark: %v", err)
+	}
+	// Watermarks written before microsecond precision hold Unix seconds
+	if revokedBefore < legacyWatermarkLimit {
+		revokedBefore *= 1e6
+	}
+
+	// Tokens without "iat" predate revocation support and are treated as old
+	issuedAt, _ := claimTime(claims, "iat")
+	if issuedAt.UnixMicro() < revokedBefore {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
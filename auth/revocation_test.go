@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func newTestRedisClient(t *testing.T) (*redis.RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewRedisClient(server.Addr(), "", 0)
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+// userClaims are the claims of an access token of userID issued at issuedAt
+func userClaims(userID string, issuedAt time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti":     "jti-" + strconv.FormatInt(issuedAt.UnixNano(), 10),
+		"user_id": userID,
+		"iat":     numericDate(issuedAt),
+		"exp":     numericDate(issuedAt.Add(time.Hour)),
+	}
+}
+
+func TestRevokeAllTokensWithinTheSameSecond(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestRedisClient(t)
+
+	// Wait for the start of a second, so that the tokens below are issued in
+	// the same second as the revocation
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	before := userClaims("user-1", time.Now())
+	time.Sleep(time.Millisecond)
+	if err := RevokeAllTokens(ctx, client, "user-1"); err != nil {
+		t.Fatalf("RevokeAllTokens: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	after := userClaims("user-1", time.Now())
+
+	if err := CheckRevoked(ctx, client, before); err != ErrTokenRevoked {
+		t.Errorf("token issued before the revocation: err = %v, want ErrTokenRevoked", err)
+	}
+	if err := CheckRevoked(ctx, client, after); err != nil {
+		t.Errorf("token issued after the revocation: err = %v, want nil", err)
+	}
+	if err := CheckRevoked(ctx, client, userClaims("user-2", time.Now().Add(-time.Minute))); err != nil {
+		t.Errorf("token of another user: err = %v, want nil", err)
+	}
+}
+
+func TestCheckRevokedLegacyWatermark(t *testing.T) {
+	ctx := context.Background()
+	client, server := newTestRedisClient(t)
+
+	// Watermarks used to be stored in Unix seconds
+	revokedAt := time.Now().Truncate(time.Second)
+	server.Set(revokedBeforeKey("user-1"), strconv.FormatInt(revokedAt.Unix(), 10))
+
+	if err := CheckRevoked(ctx, client, userClaims("user-1", revokedAt.Add(-time.Second))); err != ErrTokenRevoked {
+		t.Errorf("token issued before the watermark: err = %v, want ErrTokenRevoked", err)
+	}
+	if err := CheckRevoked(ctx, client, userClaims("user-1", revokedAt.Add(time.Second))); err != nil {
+		t.Errorf("token issued after the watermark: err = %v, want nil", err)
+	}
+
+	// Tokens issued before microsecond precision carry whole seconds
+	legacy := userClaims("user-1", revokedAt)
+	legacy["iat"] = float64(revokedAt.Unix() - 1)
+	if err := CheckRevoked(ctx, client, legacy); err != ErrTokenRevoked {
+		t.Errorf("token with a whole second iat: err = %v, want ErrTokenRevoked", err)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestRedisClient(t)
+
+	revoked := userClaims("user-1", time.Now())
+	other := userClaims("user-1", time.Now().Add(time.Second))
+	if err := RevokeToken(ctx, client, revoked); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+
+	if err := CheckRevoked(ctx, client, revoked); err != ErrTokenRevoked {
+		t.Errorf("revoked token: err = %v, want ErrTokenRevoked", err)
+	}
+	if err := CheckRevoked(ctx, client, other); err != nil {
+		t.Errorf("other token: err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a key used to sign and verify JWTs. Asymmetric keys hold a
+// private key; the public half is published in the JWKS. HMAC keys only hold
+// a shared secret and are never published.
+type SigningKey struct {
+	ID        string // Key ID, sent in the "kid" header
+	Algorithm string // JWS algorithm (RS256, ES256, EdDSA or HS256)
+	private   crypto.Signer
+	secret    []byte
+}
+
+// NewSigningKey wraps a private key (*rsa.PrivateKey, *ecdsa.PrivateKey on P-256
+// or ed25519.PrivateKey). An empty kid defaults to the RFC 7638 JWK thumbprint.
+func NewSigningKey(kid string, private crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{ID: kid, private: private}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.Algorithm = AlgRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		key.Algorithm = AlgES256
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+	}
+
+	if key.ID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+
+	return key, nil
+}
+
+// NewHMACSigningKey wraps a shared HS256 secret
+func NewHMACSigningKey(kid string, secret []byte) *SigningKey {
+	return &SigningKey{ID: kid, Algorithm: AlgHS256, secret: secret}
+}
+
+// GenerateSigningKey creates a new random key for the given algorithm
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey("", private)
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 (RSA) or SEC 1 (EC) PEM encoded private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unsupported private key format")
+}
+
+// MarshalPrivateKeyPEM encodes the key's private half as a PKCS#8 PEM block
+func (k *SigningKey) MarshalPrivateKeyPEM() ([]byte, error) {
+	if k.private == nil {
+		return nil, errors.New("not an asymmetric key")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// IsAsymmetric reports whether the key has a publishable public half
+func (k *SigningKey) IsAsymmetric() bool {
+	return k.private != nil
+}
+
+// Public returns the public key, or nil for HMAC keys
+func (k *SigningKey) Public() crypto.PublicKey {
+	if k.private == nil {
+		return nil
+	}
+	return k.private.Public()
+}
+
+// SigningMethod returns the jwt signing method for the key's algorithm
+func (k *SigningKey) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// signKey returns the value jwt expects when signing with this key
+func (k *SigningKey) signKey() interface{} {
+	if k.private == nil {
+		return k.secret
+	}
+	return k.private
+}
+
+// verifyKey returns the value jwt expects when verifying with this key
+func (k *SigningKey) verifyKey() interface{} {
+	if k.private == nil {
+		return k.secret
+	}
+	return k.private.Public()
+}
+
+// Sign signs the claims with this key and sets the "kid" header
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.SigningMethod(), claims)
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	return token.SignedString(k.signKey())
+}
+
+// loadSigningKeyFromEnv builds the signing key from the environment.
+// JWT_PRIVATE_KEY (PEM) or JWT_PRIVATE_KEY_FILE select an asymmetric key and
+// JWT_KEY_ID overrides its kid. Without a private key, JWT_SIGNING_ALG=RS256,
+// ES256 or EdDSA generates an ephemeral key, otherwise JWT_SECRET is used for HS256.
+func loadSigningKeyFromEnv() *SigningKey {
+	kid := config.GetEnv("JWT_KEY_ID", "")
+
+	keyPEM := []byte(config.GetEnv("JWT_PRIVATE_KEY", ""))
+	if path := config.GetEnv("JWT_PRIVATE_KEY_FILE", ""); len(keyPEM) == 0 && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read JWT private key file: %v", err)
+		}
+		keyPEM = data
+	}
+
+	if len(keyPEM) > 0 {
+		private, err := ParsePrivateKeyPEM(keyPEM)
+		if err != nil {
+			log.Fatalf("Failed to parse JWT private key: %v", err)
+		}
+		key, err := NewSigningKey(kid, private)
+		if err != nil {
+			log.Fatalf("Invalid JWT private key: %v", err)
+		}
+		return key
+	}
+
+	alg := strings.TrimSpace(config.GetEnv("JWT_SIGNING_ALG", AlgHS256))
+	if alg == "" || alg == AlgHS256 {
+		return NewHMACSigningKey(kid, jwtSecret)
+	}
+
+	log.Printf("No JWT private key configured, generating an ephemeral %s key", alg)
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		log.Fatalf("Failed to generate JWT signing key: %v", err)
+	}
+	if kid != "" {
+		key.ID = kid
+	}
+	return key
+}
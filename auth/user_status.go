@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrUserDeactivated is returned for tokens of a user whose account was deactivated
+var ErrUserDeactivated = errors.New("account deactivated")
+
+// deactivatedUserKey is the Redis key marking a user's account as deactivated
+func deactivatedUserKey(userID string) string {
+	return "deactivated:user:" + userID
+}
+
+// MarkUserDeactivated makes CheckUserActive refuse the user's tokens. The mark
+// outlives every access token issued before the deactivation, and no token is
+// issued to a deactivated user afterwards.
+func MarkUserDeactivated(ctx context.Context, client *redis.RedisClient, userID string) error {
+	expiration := time.Now().Add(time.Hour * time.Duration(AccessTokenExpirationHours))
+	return client.Set(ctx, deactivatedUserKey(userID), "1", expiration)
+}
+
+// ClearUserDeactivated removes the mark of a reactivated user
+func ClearUserDeactivated(ctx context.Context, client *redis.RedisClient, userID string) error {
+	return client.Delete(ctx, deactivatedUserKey(userID))
+}
+
+// CheckUserActive returns ErrUserDeactivated if the token's user was deactivated
+func CheckUserActive(ctx context.Context, client *redis.RedisClient, claims jwt.MapClaims) error {
+	userID, _ := claims["user_id"].(string)
+	if userID == "" {
+		return nil
+	}
+
+	deactivated, err := client.Exists(ctx, deactivatedUserKey(userID))
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return ErrUserDeactivated
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/keystore"
+)
+
+const usage = `Usage: auth-keys <command> [arguments]
+
+Commands:
+  list                      List stored signing keys
+  generate [-alg ES256] [-activate]
+                            Generate a new signing key (RS256, ES256 or EdDSA)
+  promote <kid>             Make a key the active signing key and retire the current one
+  prune                     Expire retired keys whose verification window has passed
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Initialize database connection
+	config.InitDB()
+
+	switch os.Args[1] {
+	case "list":
+		keys, err := keystore.List(config.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, key := range keys {
+			verifyUntil := "-"
+			if key.VerifyUntil != nil {
+				verifyUntil = key.VerifyUntil.Format(time.RFC3339)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.Status, key.CreatedAt.Format(time.RFC3339), verifyUntil)
+		}
+
+	case "generate":
+		flags := flag.NewFlagSet("generate", flag.ExitOnError)
+		alg := flags.String("alg", "ES256", "signing algorithm (RS256, ES256 or EdDSA)")
+		activate := flags.Bool("activate", false, "promote the key to active right away")
+		flags.Parse(os.Args[2:])
+
+		key, err := keystore.Generate(config.DB, *alg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *activate {
+			if err := keystore.Promote(config.DB, key.ID); err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Println(key.ID)
+
+	case "promote":
+		if len(os.Args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		if err := keystore.Promote(config.DB, os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Promoted %s\n", os.Args[2])
+
+	case "prune":
+		expired, err := keystore.Prune(config.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Expired %d key(s)\n", expired)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
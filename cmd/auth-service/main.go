@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/keystore"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
 	"github.com/drive-deep/auth-microservices/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -14,6 +18,18 @@ func main() {
 
 	config.InitDB()
 
+	// Initialize Redis connection
+	config.InitRedis()
+
+	// Initialize outbound email
+	config.InitMailer()
+
+	// Load the signing keys stored in the database and keep them up to date
+	if err := keystore.Load(config.DB); err != nil {
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	go keystore.StartRefresher(context.Background(), config.DB, time.Minute)
+
 	// Create a new Fiber app
 	app := fiber.New()
 
@@ -22,7 +38,7 @@ func main() {
 		Format:     "[${time}] ${status} - ${method} ${path}\n", // Customize log format if needed
 		TimeFormat: "02-Jan-2006",
 	})) // Logging middleware for request logging
-	//app.Use(middlewares.RateLimitMiddleware) // Apply rate limiting
+	app.Use(middlewares.RateLimitMiddleware) // Apply rate limiting
 
 	// Define routes and handlers
 	app.Get("/", func(c *fiber.Ctx) error {
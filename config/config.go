@@ -1,14 +1,22 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/drive-deep/auth-microservices/mailer"
 	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/redis"
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 // DB holds the database connection instance
@@ -47,6 +55,81 @@ func InitDB() {
 	}
 }
 
+// Redis holds the Redis client instance
+var Redis *redis.RedisClient
+
+// InitRedis initializes the Redis connection used for token revocation and other short-lived state
+func InitRedis() {
+	client, err := redis.InitRedis()
+	if err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+
+	Redis = client
+}
+
+// Mailer sends email in the background, MailTemplates renders the messages
+var (
+	Mailer        mailer.Mailer
+	MailTemplates *mailer.Templates
+)
+
+// InitMailer sets up outbound email. MAIL_DRIVER selects the delivery: smtp,
+// file (a maildir under MAIL_DIR, the default for development) or memory.
+// Messages are queued and delivered by background workers.
+func InitMailer() {
+	var delivery mailer.Mailer
+	switch driver := GetEnv("MAIL_DRIVER", "file"); driver {
+	case "smtp":
+		delivery = mailer.NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			GetEnvInt("SMTP_PORT", 587),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		)
+	case "file":
+		fileMailer, err := mailer.NewFileMailer(GetEnv("MAIL_DIR", "mail"))
+		if err != nil {
+			log.Fatal("Failed to create mail directory:", err)
+		}
+		delivery = fileMailer
+	case "memory":
+		delivery = mailer.NewMemoryMailer()
+	default:
+		log.Fatalf("Unknown MAIL_DRIVER %q", driver)
+	}
+
+	templates, err := mailer.EmbeddedTemplates()
+	if err != nil {
+		log.Fatal("Failed to load mail templates:", err)
+	}
+	templates.DefaultLocale = GetEnv("MAIL_DEFAULT_LOCALE", "en")
+
+	queue := mailer.NewQueue(delivery, GetEnvInt("MAIL_QUEUE_SIZE", 1000))
+	queue.MaxAttempts = GetEnvInt("MAIL_MAX_ATTEMPTS", 5)
+	queue.Start(context.Background(), GetEnvInt("MAIL_WORKERS", 2))
+
+	Mailer = queue
+	MailTemplates = templates
+}
+
+// GetEnv reads a string from the environment, falling back to def
+func GetEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// GetEnvInt reads a positive integer from the environment, falling back to def
+func GetEnvInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
 // SetupAppConfig sets up the application-wide configurations like middleware, logging, etc.
 func SetupAppConfig(app *fiber.App) {
 	// Set up global middleware or app settings here
@@ -67,8 +150,121 @@ func createSchema(db *pg.DB) error {
 		if err != nil {
 			return err
 		}
+
+		// Tables created by older versions lack the columns added since
+		err = addMissingColumns(db, model)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, index := range models.GetAllIndexes() {
+		if _, err := db.Exec(index); err != nil {
+			return err
+		}
+	}
+
+	if err := seedRoles(db); err != nil {
+		return err
 	}
 
 	log.Println("Database schema created successfully")
 	return nil
 }
+
+// seedRoles creates the admin role and the built-in permissions. A permission
+// is granted to the admin role when it is created, so permissions removed from
+// the role later are not granted again.
+func seedRoles(db *pg.DB) error {
+	now := time.Now()
+	admin := &models.Role{
+		ID:          uuid.New().String(),
+		Name:        models.RoleAdmin,
+		Description: "Full access to the service",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	_, err := db.Model(admin).OnConflict("(name) DO NOTHING").Insert()
+	if err != nil {
+		return err
+	}
+	if err := db.Model(admin).Where("name = ?", models.RoleAdmin).Select(); err != nil {
+		return err
+	}
+
+	for _, name := range models.BuiltinPermissions {
+		permission := &models.Permission{
+			ID:        uuid.New().String(),
+			Name:      name,
+			CreatedAt: now,
+		}
+		res, err := db.Model(permission).OnConflict("(name) DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			continue
+		}
+
+		grant := &models.RolePermission{RoleID: admin.ID, PermissionID: permission.ID, CreatedAt: now}
+		if _, err := db.Model(grant).OnConflict("DO NOTHING").Insert(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addMissingColumns adds the model's columns that do not exist in its table yet.
+// Added columns are nullable unless the field declares a default, so existing
+// rows remain valid. Existing rows are then backfilled for the added columns
+// that need it (models.GetColumnBackfills), in the same transaction.
+func addMissingColumns(db *pg.DB, model interface{}) error {
+	table := orm.GetTable(reflect.TypeOf(model).Elem())
+	tableName := strings.Trim(string(table.SQLName), `"`)
+	backfills := models.GetColumnBackfills()
+
+	var existing []string
+	_, err := db.Query(&existing, `SELECT attname FROM pg_attribute WHERE attrelid = ?::regclass AND attnum > 0 AND NOT attisdropped`, string(table.SQLName))
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, column := range existing {
+		exists[column] = true
+	}
+
+	return db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		var added []string
+		for _, field := range table.Fields {
+			if exists[field.SQLName] {
+				continue
+			}
+
+			sqlType := field.UserSQLType
+			if sqlType == "" {
+				sqlType = field.SQLType
+			}
+
+			query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table.SQLName, field.Column, sqlType)
+			if field.Default != "" {
+				query += fmt.Sprintf(" DEFAULT %s", field.Default)
+			}
+
+			if _, err := tx.Exec(query); err != nil {
+				return err
+			}
+			added = append(added, field.SQLName)
+		}
+
+		// Backfill once every column is there, a backfill may set several
+		for _, column := range added {
+			if backfill, ok := backfills[tableName+"."+column]; ok {
+				if _, err := tx.Exec(backfill); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// apiKeyDefaultTTLDays and apiKeyMaxTTLDays bound the lifetime of API keys
+var (
+	apiKeyDefaultTTLDays = config.GetEnvInt("API_KEY_DEFAULT_TTL_DAYS", 90)
+	apiKeyMaxTTLDays     = config.GetEnvInt("API_KEY_MAX_TTL_DAYS", 365)
+)
+
+// apiKeyLimit is how many API keys a user can hold, expired ones included
+var apiKeyLimit = config.GetEnvInt("API_KEY_LIMIT", 25)
+
+// CreateAPIKeyRequest struct to capture the name, scopes and lifetime of a new API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// ListAPIKeys lists the logged-in user's API keys, newest first
+func ListAPIKeys(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	keys := []models.APIKey{}
+	if err := config.DB.Model(&keys).Where("user_id = ?", userID).Order("created_at DESC").Select(); err != nil {
+		log.Printf("Error querying API keys: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// CreateAPIKey creates an API key for the logged-in user. Scopes must be
+// permissions the user holds, and the key is scoped to the current
+// organization of the token. The key is only returned in this response.
+func CreateAPIKey(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	orgID, _ := c.Locals("org_id").(string)
+
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = apiKeyDefaultTTLDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > apiKeyMaxTTLDays {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_in_days must be between 1 and " + strconv.Itoa(apiKeyMaxTTLDays),
+		})
+	}
+
+	_, permissions, err := userAuthorization(userID)
+	if err != nil {
+		log.Printf("Error querying user permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !containsString(permissions, scope) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "You do not hold the permission " + scope,
+			})
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	count, err := config.DB.Model((*models.APIKey)(nil)).Where("user_id = ?", userID).Count()
+	if err != nil {
+		log.Printf("Error counting API keys: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if count >= apiKeyLimit {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Too many API keys, delete unused ones first",
+		})
+	}
+
+	key, prefix, keyHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	now := time.Now()
+	apiKey := &models.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		OrgID:     orgID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		ExpiresAt: now.AddDate(0, 0, req.ExpiresInDays),
+		CreatedAt: now,
+	}
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(apiKey).Insert(); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "api_key.create", "api_key", apiKey.ID, map[string]interface{}{
+			"name":       apiKey.Name,
+			"scopes":     apiKey.Scopes,
+			"expires_at": apiKey.ExpiresAt,
+		})
+	})
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// DeleteAPIKey deletes one of the logged-in user's API keys, it stops working at once
+func DeleteAPIKey(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var apiKey models.APIKey
+	var deleted bool
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(&apiKey).
+			Where("id = ?", c.Params("id")).
+			Where("user_id = ?", userID).
+			Returning("*").
+			Delete()
+		if err != nil {
+			return err
+		}
+		if deleted = res.RowsAffected() > 0; !deleted {
+			return nil
+		}
+		return recordAudit(tx, c, "api_key.delete", "api_key", apiKey.ID, map[string]interface{}{
+			"name": apiKey.Name,
+		})
+	})
+	if err != nil {
+		log.Printf("Error deleting API key: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if !deleted {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "API key not found",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "API key deleted",
+	})
+}
+
+// containsString reports whether values holds want
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/drive-deep/auth-microservices/models"
 	"github.com/go-pg/pg/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 )
 
@@ -20,6 +22,12 @@ type SignUpRequest struct {
 	LastName  string `json:"last_name"`
 }
 
+// signUpOptions adjusts the sign-up of users who were invited
+type signUpOptions struct {
+	EmailVerified bool                                     // The address is already proven, no verification email is sent
+	OnCreate      func(tx *pg.Tx, user *models.User) error // Runs in the transaction creating the user
+}
+
 // SignUp handles user sign-up
 // SignUp handles user sign-up
 func SignUp(c *fiber.Ctx) error {
@@ -32,6 +40,11 @@ func SignUp(c *fiber.Ctx) error {
 		})
 	}
 
+	return signUp(c, req, signUpOptions{})
+}
+
+// signUp validates the sign-up request, creates the user and answers the request
+func signUp(c *fiber.Ctx, req SignUpRequest, opts signUpOptions) error {
 	// Validate input (you can add more validation if needed)
 	if req.Email == "" || req.Password == "" || req.FirstName == "" || req.LastName == "" {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
@@ -39,6 +52,18 @@ func SignUp(c *fiber.Ctx) error {
 		})
 	}
 
+	// Enforce the password policy before touching the database
+	violations, err := auth.DefaultPasswordPolicy.Check(req.Password, req.Email, req.FirstName, req.LastName)
+	if err != nil {
+		log.Printf("Error checking password policy: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if len(violations) > 0 {
+		return passwordPolicyError(c, violations)
+	}
+
 	// Check if the email already exists in the database using CheckEmailExists
 	emailExists, err := CheckEmailExists(config.DB, req.Email)
 	if err != nil {
@@ -57,17 +82,8 @@ func SignUp(c *fiber.Ctx) error {
 	}
 
 	// Proceed with sign-up since the email does not exist
-	// Generate a salt
-	salt, err := auth.GenerateSalt()
-	if err != nil {
-		log.Printf("Error generating salt: %v", err)
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Internal server error",
-		})
-	}
-
-	// Hash the password with the salt
-	hashedPassword, err := auth.HashPasswordWithSalt(req.Password, salt)
+	// Hash the password with the configured password hasher
+	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
@@ -79,16 +95,33 @@ func SignUp(c *fiber.Ctx) error {
 	user := models.User{
 		ID:        uuid.New().String(),
 		Email:     req.Email,
-		Password:  string(hashedPassword), // Store hashed password
-		Salt:      salt,                   // Store salt
+		Password:  hashedPassword, // Store hashed password (PHC string, salt included)
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
+		Status:    models.UserStatusActive,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		// EmailVerified stays false until the link sent below is followed,
+		// unless the address was already proven
+		EmailVerified: opts.EmailVerified,
+	}
+	if opts.EmailVerified {
+		user.EmailVerifiedAt = &user.CreatedAt
 	}
 
 	// Save the user to the database
-	_, err = config.DB.Model(&user).Insert()
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(&user).Insert(); err != nil {
+			return err
+		}
+		if opts.OnCreate != nil {
+			return opts.OnCreate(tx, &user)
+		}
+		return nil
+	})
+	if err == errInvalidInvitation {
+		return invalidInvitationError(c)
+	}
 	if err != nil {
 		log.Printf("Error inserting user into database: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
@@ -96,9 +129,22 @@ func SignUp(c *fiber.Ctx) error {
 		})
 	}
 
+	if user.EmailVerified {
+		return c.Status(http.StatusCreated).JSON(fiber.Map{
+			"message": "User created successfully",
+			"user":    req,
+		})
+	}
+
+	// Send the verification link, the account is created even if this fails
+	// since the user can ask for a new link
+	if err := sendVerificationEmail(c.Context(), &user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+	}
+
 	// Return success response
 	return c.Status(http.StatusCreated).JSON(fiber.Map{
-		"message": "User created successfully",
+		"message": "User created successfully, check your inbox to verify your email",
 		"user":    req,
 	})
 }
@@ -121,63 +167,191 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if the user exists using CheckEmailExists
-	emailExists, err := CheckEmailExists(config.DB, req.Email)
+	// Verify the email and password
+	user, err := authenticateUser(c, req.Email, req.Password)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errUserNotFound {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "User not registered",
+		})
+	}
+	if err == errInvalidCredentials {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid credentials",
+		})
+	}
+	if err == nil {
+		err = checkEmailVerified(user)
+	}
+	if err == errEmailNotVerified {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Email not verified",
+		})
+	}
 	if err != nil {
-		log.Printf("Error checking email existence: %v", err)
+		log.Printf("Error authenticating user: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Internal server error",
 		})
 	}
 
-	// If the user does not exist, return a 400 error
-	if !emailExists {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"error": "User not registered",
-		})
+	return completeLogin(c, user)
+}
+
+// completeLogin answers a successful first factor the way Login does: users
+// with a second factor get a challenge to complete at /login/mfa, others get
+// their tokens
+func completeLogin(c *fiber.Ctx, user *models.User) error {
+	if !user.Active() {
+		return accountDeactivatedError(c)
 	}
 
-	// Retrieve the user from the database
-	var user models.User
-	err = config.DB.Model(&user).Where("email = ?", req.Email).Select()
+	methods, err := mfaMethods(user.ID)
 	if err != nil {
-		log.Printf("Error querying user: %v", err)
+		log.Printf("Error querying MFA methods: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Internal server error",
 		})
 	}
+	if len(methods) > 0 {
+		mfaToken, err := startMFAChallenge(c, user)
+		if err != nil {
+			log.Printf("Error starting MFA challenge: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"methods":      methods,
+			"expires_in":   int(mfaChallengeTTL.Seconds()),
+		})
+	}
 
-	// Hash the provided password with the salt stored in the DB
-	hashedPassword, err := auth.HashPasswordWithSalt(req.Password, user.Salt)
+	// Generate the access token and start a new refresh token family
+	tokens, err := issueTokens(user, tokenOptions{})
 	if err != nil {
-		log.Printf("Error hashing password: %v", err)
+		log.Printf("Error generating tokens: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Error generating hashed password",
+			"error": "Error generating token",
 		})
 	}
 
-	// Compare the generated hash with the stored password hash
-	if string(hashedPassword) != user.Password {
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid credentials",
-		})
+	// Return the access and refresh tokens
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// errUserNotFound and errInvalidCredentials are returned by authenticateUser
+var (
+	errUserNotFound       = errors.New("user not registered")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// authenticateUser verifies an email and password pair and returns the user.
+// Failed attempts are throttled per account and client IP (a *auth.LockoutError
+// is returned while throttled). Legacy or weaker password hashes are upgraded
+// on success.
+func authenticateUser(c *fiber.Ctx, email, password string) (*models.User, error) {
+	// Retrieve the user from the database
+	var user models.User
+	err := config.DB.Model(&user).Where("email = ?", email).Select()
+	if err == pg.ErrNoRows {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Email, 1)
+	needsRehash, err := verifyUserPassword(c, &user, password)
 	if err != nil {
-		log.Printf("Error generating JWT token: %v", err)
+		return nil, err
+	}
+
+	// Transparently upgrade legacy or weaker hashes now that we know the plaintext
+	if needsRehash {
+		rehashPassword(&user, password)
+	}
+
+	return &user, nil
+}
+
+// LogoutRequest struct to capture the optional refresh token to revoke on logout
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout revokes the access token used for the request and, if provided, the
+// refresh token family (session) it belongs to
+func Logout(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(jwt.MapClaims)
+	userID, _ := c.Locals("user_id").(string)
+
+	var req LogoutRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input data",
+			})
+		}
+	}
+
+	if err := auth.RevokeToken(c.Context(), config.Redis, claims); err != nil {
+		log.Printf("Error revoking access token: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Error generating token",
+			"error": "Internal server error",
+		})
+	}
+
+	if req.RefreshToken != "" {
+		var record models.RefreshToken
+		err := config.DB.Model(&record).
+			Where("token_hash = ?", auth.HashOpaqueToken(req.RefreshToken)).
+			Where("user_id = ?", userID).
+			Select()
+		if err == nil {
+			err = revokeTokenFamily(record.FamilyID)
+		}
+		if err != nil && err != pg.ErrNoRows {
+			log.Printf("Error revoking refresh token: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Logged out successfully",
+	})
+}
+
+// LogoutAll revokes every access and refresh token issued to the user
+func LogoutAll(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	if err := revokeAllSessions(c.Context(), userID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
 		})
 	}
 
-	// Return the JWT token
 	return c.Status(http.StatusOK).JSON(fiber.Map{
-		"token": token,
+		"message": "Logged out from all sessions",
 	})
 }
 
+// rehashPassword re-hashes the user's password with the default hasher and
+// stores it. Failures are logged only, the login itself already succeeded.
+func rehashPassword(user *models.User, password string) {
+	if err := setPassword(user, password); err != nil {
+		log.Printf("Error storing rehashed password for user %s: %v", user.ID, err)
+	}
+}
+
 // Update CheckEmailExists function to use the correct version of pg.DB
 func CheckEmailExists(db *pg.DB, email string) (bool, error) {
 	var user models.User
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/drive-deep/auth-microservices/views"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// authorizationRequestTTL is how long the login/consent page stays valid
+const authorizationRequestTTL = 10 * time.Minute
+
+// authorizationCodeTTL is how long an authorization code can be exchanged
+const authorizationCodeTTL = time.Minute
+
+// authorizationRequest is a validated authorization request waiting for the
+// user to log in and consent. It is kept in Redis so the parameters cannot be
+// tampered with between rendering the page and submitting it.
+type authorizationRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	RedirectURISupplied bool   `json:"redirect_uri_supplied"` // False when defaulted to the only registered URI
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+}
+
+// authorizationCode is what an authorization code stands for until it is exchanged
+type authorizationCode struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	RedirectURISupplied bool   `json:"redirect_uri_supplied"` // The token request must then repeat the redirect URI
+	Scope               string `json:"scope"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	UserID              string `json:"user_id"`
+	AuthTime            int64  `json:"auth_time"`
+}
+
+// authorizePage is the data rendered by the login/consent page
+type authorizePage struct {
+	RequestID  string
+	ClientName string
+	Scopes     []string
+	Email      string
+	MFAToken   string // Set on the second factor step
+	Error      string
+}
+
+// Authorize validates an authorization request (RFC 6749 section 4.1.1 with
+// PKCE, RFC 7636) and renders the login/consent page
+func Authorize(c *fiber.Ctx) error {
+	client, err := findClient(c.Query("client_id"))
+	if err != nil {
+		if err != errInvalidClient {
+			log.Printf("Error querying client: %v", err)
+			return oauthError(c, http.StatusInternalServerError, "server_error", "Internal server error")
+		}
+		return oauthError(c, http.StatusBadRequest, "invalid_request", "Unknown client_id")
+	}
+
+	// Never redirect to an unregistered URI, report the error to the user instead
+	redirectURI := c.Query("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.HasRedirectURI(redirectURI) {
+		return oauthError(c, http.StatusBadRequest, "invalid_request", "Invalid redirect_uri")
+	}
+
+	state := c.Query("state")
+	if c.Query("response_type") != "code" {
+		return redirectWithError(c, redirectURI, state, "unsupported_response_type", "Only the code response type is supported")
+	}
+
+	codeChallenge := c.Query("code_challenge")
+	if codeChallenge == "" || c.Query("code_challenge_method") != auth.PKCEMethodS256 {
+		return redirectWithError(c, redirectURI, state, "invalid_request", "PKCE with code_challenge_method S256 is required")
+	}
+	if !auth.ValidPKCEChallenge(codeChallenge) {
+		return redirectWithError(c, redirectURI, state, "invalid_request", "code_challenge must be a base64url encoded SHA-256 digest")
+	}
+
+	scope := normalizeScope(c.Query("scope"))
+	if !client.AllowsScope(scope) {
+		return redirectWithError(c, redirectURI, state, "invalid_scope", "The requested scope is not allowed for this client")
+	}
+
+	req := authorizationRequest{
+		ClientID:            client.ID,
+		RedirectURI:         redirectURI,
+		RedirectURISupplied: c.Query("redirect_uri") != "",
+		Scope:               scope,
+		State:               state,
+		Nonce:               c.Query("nonce"),
+		CodeChallenge:       codeChallenge,
+	}
+
+	requestID := uuid.New().String()
+	if err := storeJSON(c.Context(), authorizationRequestKey(requestID), req, authorizationRequestTTL); err != nil {
+		log.Printf("Error storing authorization request: %v", err)
+		return redirectWithError(c, redirectURI, state, "server_error", "Internal server error")
+	}
+
+	return renderAuthorizePage(c, http.StatusOK, authorizePage{
+		RequestID:  requestID,
+		ClientName: client.Name,
+		Scopes:     strings.Fields(req.Scope),
+	})
+}
+
+// AuthorizeDecision handles the submitted login/consent page. On success the
+// user agent is redirected back to the client with an authorization code.
+func AuthorizeDecision(c *fiber.Ctx) error {
+	requestID := c.FormValue("request_id")
+
+	var req authorizationRequest
+	if err := loadJSON(c.Context(), authorizationRequestKey(requestID), &req); err != nil {
+		if err != redis.ErrKeyNotFound {
+			log.Printf("Error loading authorization request: %v", err)
+		}
+		return oauthError(c, http.StatusBadRequest, "invalid_request", "Authorization request expired, please start again")
+	}
+
+	if c.FormValue("decision") != "allow" {
+		config.Redis.Delete(c.Context(), authorizationRequestKey(requestID))
+		return redirectWithError(c, req.RedirectURI, req.State, "access_denied", "The user denied the request")
+	}
+
+	// Re-render the page (login or second factor step) with an error
+	email := c.FormValue("email")
+	retry := func(status int, message, mfaToken string) error {
+		page := authorizePage{
+			RequestID: requestID,
+			Scopes:    strings.Fields(req.Scope),
+			Email:     email,
+			MFAToken:  mfaToken,
+			Error:     message,
+		}
+		if client, _ := findClient(req.ClientID); client != nil {
+			page.ClientName = client.Name
+		}
+		return renderAuthorizePage(c, status, page)
+	}
+
+	var user *models.User
+	var err error
+	if mfaToken := c.FormValue("mfa_token"); mfaToken != "" {
+		// Second step: the page asks for a TOTP code or a recovery code in the same field
+		code, recoveryCode := c.FormValue("code"), ""
+		if len(strings.ReplaceAll(strings.TrimSpace(code), " ", "")) != auth.TOTPDigits {
+			code, recoveryCode = "", code
+		}
+
+		user, err = completeMFAChallenge(c, mfaToken, secondFactor{Code: code, RecoveryCode: recoveryCode})
+		var lockoutErr *auth.LockoutError
+		if errors.As(err, &lockoutErr) {
+			return retry(http.StatusTooManyRequests, "Too many failed attempts, please try again later", mfaToken)
+		}
+		if err == errInvalidMFAChallenge {
+			return retry(http.StatusUnauthorized, "Your sign-in expired, please sign in again", "")
+		}
+		if err == errInvalidMFACode {
+			return retry(http.StatusUnauthorized, "Invalid code", mfaToken)
+		}
+	} else {
+		user, err = authenticateUser(c, email, c.FormValue("password"))
+		if err == nil {
+			err = checkEmailVerified(user)
+		}
+		var lockoutErr *auth.LockoutError
+		if errors.As(err, &lockoutErr) {
+			return retry(http.StatusTooManyRequests, "Too many failed attempts, please try again later", "")
+		}
+		if err == errUserNotFound || err == errInvalidCredentials {
+			return retry(http.StatusUnauthorized, "Invalid email or password", "")
+		}
+		if err == errEmailNotVerified {
+			return retry(http.StatusUnauthorized, "Please verify your email address first", "")
+		}
+
+		// Users with a second factor continue with the code step
+		var methods []string
+		if err == nil {
+			methods, err = mfaMethods(user.ID)
+		}
+		if err == nil && len(methods) > 0 {
+			mfaToken, err := startMFAChallenge(c, user)
+			if err != nil {
+				log.Printf("Error starting MFA challenge: %v", err)
+				return redirectWithError(c, req.RedirectURI, req.State, "server_error", "Internal server error")
+			}
+			return retry(http.StatusOK, "", mfaToken)
+		}
+	}
+	if err != nil {
+		log.Printf("Error authenticating user: %v", err)
+		return redirectWithError(c, req.RedirectURI, req.State, "server_error", "Internal server error")
+	}
+
+	// The request is consumed, a new login needs a new authorization request
+	config.Redis.Delete(c.Context(), authorizationRequestKey(requestID))
+
+	code, codeHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		log.Printf("Error generating authorization code: %v", err)
+		return redirectWithError(c, req.RedirectURI, req.State, "server_error", "Internal server error")
+	}
+
+	grant := authorizationCode{
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		RedirectURISupplied: req.RedirectURISupplied,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		UserID:              user.ID,
+		AuthTime:            time.Now().Unix(),
+	}
+	if err := storeJSON(c.Context(), authorizationCodeKey(codeHash), grant, authorizationCodeTTL); err != nil {
+		log.Printf("Error storing authorization code: %v", err)
+		return redirectWithError(c, req.RedirectURI, req.State, "server_error", "Internal server error")
+	}
+
+	return redirectWithParams(c, req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	})
+}
+
+// consumeAuthorizationCode returns the grant behind an authorization code and
+// deletes it, so every code can be exchanged only once
+func consumeAuthorizationCode(ctx context.Context, code string) (*authorizationCode, error) {
+	var grant authorizationCode
+	if err := consumeJSON(ctx, authorizationCodeKey(auth.HashOpaqueToken(code)), &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// authorizationRequestKey is the Redis key of a pending authorization request
+func authorizationRequestKey(requestID string) string {
+	return "oauth:authreq:" + requestID
+}
+
+// authorizationCodeKey is the Redis key of an authorization code (by hash)
+func authorizationCodeKey(codeHash string) string {
+	return "oauth:code:" + codeHash
+}
+
+// renderAuthorizePage renders the login/consent page
+func renderAuthorizePage(c *fiber.Ctx, status int, page authorizePage) error {
+	var body bytes.Buffer
+	if err := views.Render(&body, "authorize.html", page); err != nil {
+		log.Printf("Error rendering authorize page: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString("Internal server error")
+	}
+
+	// The page collects credentials, it must not be framed or cached
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(status).Send(body.Bytes())
+}
+
+// redirectWithError redirects back to the client with an OAuth error
+func redirectWithError(c *fiber.Ctx, redirectURI, state, code, description string) error {
+	return redirectWithParams(c, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+// redirectWithParams redirects to the redirect URI with extra query parameters
+func redirectWithParams(c *fiber.Ctx, redirectURI string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return oauthError(c, http.StatusBadRequest, "invalid_request", "Invalid redirect_uri")
+	}
+
+	query := u.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return c.Redirect(u.String(), http.StatusFound)
+}
+
+// normalizeScope removes duplicate scopes, keeping the requested order
+func normalizeScope(scope string) string {
+	seen := map[string]bool{}
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newAuthorizationCodeFixture serves a confidential client and a user from a
+// fake database and routes the authorization and token endpoints
+func newAuthorizationCodeFixture(t *testing.T) (*fiber.App, *models.User) {
+	newTestRedis(t)
+	db := newFakePostgres(t)
+	user := newTestUser(t)
+
+	client := &models.OAuthClient{
+		ID:           testClientID,
+		Name:         "Web app",
+		SecretHash:   auth.HashOpaqueToken(testClientSecret),
+		RedirectURIs: []string{testRedirectURI},
+	}
+	db.Handle(`FROM "oauth_clients"`, func(string) fakeResult {
+		return fakeRows(client)
+	})
+	db.Handle(`FROM "users"`, func(string) fakeResult {
+		return fakeRows(user)
+	})
+	db.Handle(`FROM "roles"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	db.Handle(`FROM "permissions"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	db.Handle(`FROM "memberships"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	newRefreshTokenStore(db)
+
+	app := fiber.New()
+	app.Get("/oauth/authorize", Authorize)
+	app.Post("/oauth/token", Token)
+	return app, user
+}
+
+func TestAuthorizeValidatesCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		valid     bool
+	}{
+		{"S256 digest", auth.PKCEChallenge(testCodeVerifier), true},
+		{"missing", "", false},
+		{"plain verifier", testCodeVerifier + "abc", false},
+		{"too short", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw", false},
+		{"padded", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-c=", false},
+		{"standard base64", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw+c", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newAuthorizationCodeFixture(t)
+
+			query := url.Values{
+				"response_type":         {"code"},
+				"client_id":             {testClientID},
+				"redirect_uri":          {testRedirectURI},
+				"state":                 {"xyz"},
+				"code_challenge":        {tt.challenge},
+				"code_challenge_method": {auth.PKCEMethodS256},
+			}
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil), -1)
+			if err != nil {
+				t.Fatalf("GET /oauth/authorize: %v", err)
+			}
+			resp.Body.Close()
+
+			if tt.valid {
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("status = %d, want the login page", resp.StatusCode)
+				}
+				return
+			}
+			location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+			if resp.StatusCode != http.StatusFound || err != nil || location.Query().Get("error") != "invalid_request" {
+				t.Errorf("response = %d %s, want a redirect with invalid_request", resp.StatusCode, location)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		verifier    string
+		redirectURI string
+		status      int
+	}{
+		{"valid", testCodeVerifier, testRedirectURI, http.StatusOK},
+		{"wrong verifier", strings.Repeat("a", 43), testRedirectURI, http.StatusBadRequest},
+		{"missing verifier", "", testRedirectURI, http.StatusBadRequest},
+		{"redirect_uri mismatch", testCodeVerifier, "https://evil.example.com/callback", http.StatusBadRequest},
+		{"missing redirect_uri", testCodeVerifier, "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, user := newAuthorizationCodeFixture(t)
+
+			code, codeHash, err := auth.GenerateOpaqueToken()
+			if err != nil {
+				t.Fatalf("GenerateOpaqueToken: %v", err)
+			}
+			grant := authorizationCode{
+				ClientID:            testClientID,
+				RedirectURI:         testRedirectURI,
+				RedirectURISupplied: true,
+				Scope:               "profile",
+				CodeChallenge:       auth.PKCEChallenge(testCodeVerifier),
+				UserID:              user.ID,
+				AuthTime:            time.Now().Unix(),
+			}
+			if err := storeJSON(context.Background(), authorizationCodeKey(codeHash), grant, authorizationCodeTTL); err != nil {
+				t.Fatalf("storeJSON: %v", err)
+			}
+
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code},
+				"redirect_uri":  {tt.redirectURI},
+				"code_verifier": {tt.verifier},
+			}
+			status, body := doForm(t, app, "/oauth/token", form, testClientID, testClientSecret)
+			if status != tt.status {
+				t.Fatalf("token = %d %v, want %d", status, body, tt.status)
+			}
+			if status != http.StatusOK {
+				if body["error"] != "invalid_grant" {
+					t.Errorf("error = %v, want invalid_grant", body["error"])
+				}
+				return
+			}
+			if token, _ := body["access_token"].(string); token == "" {
+				t.Errorf("no access_token in %v", body)
+			}
+
+			// Codes are single use
+			status, body = doForm(t, app, "/oauth/token", form, testClientID, testClientSecret)
+			if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+				t.Errorf("second exchange = %d %v, want invalid_grant", status, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// errInvalidClient is returned when client authentication fails
+var errInvalidClient = errors.New("invalid client")
+
+// CreateClientRequest struct to capture the client to register
+type CreateClientRequest struct {
+	Name          string   `json:"name"`
+	Public        bool     `json:"public"`
+	RedirectURIs  []string `json:"redirect_uris"`
+	AllowedScopes []string `json:"allowed_scopes"`
+	Audience      string   `json:"audience"`
+}
+
+// CreateClient registers a new OAuth client. The secret of a confidential client
+// is only returned once.
+func CreateClient(c *fiber.Ctx) error {
+	var req CreateClientRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	if req.Name == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing required fields",
+		})
+	}
+
+	for _, redirectURI := range req.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid redirect URI: " + redirectURI,
+			})
+		}
+	}
+
+	client := models.OAuthClient{
+		ID:            uuid.New().String(),
+		Name:          req.Name,
+		Public:        req.Public,
+		RedirectURIs:  req.RedirectURIs,
+		AllowedScopes: req.AllowedScopes,
+		Audience:      req.Audience,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	// Public clients cannot keep a secret, so they do not get one
+	var secret string
+	if !client.Public {
+		var err error
+		secret, client.SecretHash, err = auth.GenerateOpaqueToken()
+		if err != nil {
+			log.Printf("Error generating client secret: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+	}
+
+	if _, err := config.DB.Model(&client).Insert(); err != nil {
+		log.Printf("Error inserting client into database: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create client",
+		})
+	}
+
+	response := fiber.Map{"client": client}
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+	return c.Status(http.StatusCreated).JSON(response)
+}
+
+// validRedirectURI reports whether a redirect URI can be registered: an absolute
+// URI without fragment, using https except for loopback addresses
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	// https and custom schemes for native apps
+	return true
+}
+
+// ListClients returns every registered OAuth client
+func ListClients(c *fiber.Ctx) error {
+	var clients []models.OAuthClient
+	if err := config.DB.Model(&clients).Order("created_at").Select(); err != nil {
+		log.Printf("Error fetching clients: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch clients",
+		})
+	}
+
+	if clients == nil {
+		clients = []models.OAuthClient{}
+	}
+	return c.Status(http.StatusOK).JSON(clients)
+}
+
+// DeleteClient removes a registered OAuth client
+func DeleteClient(c *fiber.Ctx) error {
+	res, err := config.DB.Model((*models.OAuthClient)(nil)).Where("id = ?", c.Params("id")).Delete()
+	if err != nil {
+		log.Printf("Error deleting client: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete client",
+		})
+	}
+
+	if res.RowsAffected() == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Client not found",
+		})
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// clientCredentials extracts the client ID and secret from HTTP Basic
+// authentication or, failing that, from the client_id/client_secret form fields
+func clientCredentials(c *fiber.Ctx) (string, string) {
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	if strings.HasPrefix(authHeader, "Basic ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Basic "))
+		if err == nil {
+			if id, secret, ok := strings.Cut(string(decoded), ":"); ok {
+				// RFC 6749 section 2.3.1: both values are form-urlencoded
+				id, _ = url.QueryUnescape(id)
+				secret, _ = url.QueryUnescape(secret)
+				return id, secret
+			}
+		}
+	}
+
+	return c.FormValue("client_id"), c.FormValue("client_secret")
+}
+
+// identifyClient identifies the client sending the request. Confidential
+// clients must authenticate with their secret; public clients only send their ID.
+func identifyClient(c *fiber.Ctx) (*models.OAuthClient, error) {
+	clientID, secret := clientCredentials(c)
+
+	client, err := findClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	secretHash := auth.HashOpaqueToken(secret)
+	if secret == "" || client.SecretHash == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+// findClient loads a registered client by ID
+func findClient(clientID string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	var client models.OAuthClient
+	err := config.DB.Model(&client).Where("id = ?", clientID).Select()
+	if err == pg.ErrNoRows {
+		return nil, errInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// authenticateClient verifies the credentials of a confidential client
+func authenticateClient(c *fiber.Ctx) (*models.OAuthClient, error) {
+	client, err := identifyClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
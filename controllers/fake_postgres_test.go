@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// fakePostgres is an in-process server speaking enough of the PostgreSQL
+// simple query protocol for go-pg, so that handlers can run without a
+// database. Tests answer the statements they expect with Handle; any other
+// statement fails the test.
+type fakePostgres struct {
+	t        *testing.T
+	listener net.Listener
+
+	mu         sync.Mutex
+	handlers   []fakeHandler
+	queries    []string
+	unexpected []string
+}
+
+// fakeHandler answers the statements containing fragment
+type fakeHandler struct {
+	fragment string
+	answer   func(query string) fakeResult
+}
+
+// fakeResult is the answer to a statement: rows for SELECT and RETURNING, the
+// command tag for the others, or an error
+type fakeResult struct {
+	columns []string
+	rows    [][][]byte // Text values, nil for NULL
+	tag     string     // Defaults to "SELECT <rows>"
+	errCode string     // SQLSTATE of an error response
+}
+
+// newFakePostgres starts a fake server and points config.DB at it until the
+// end of the test
+func newFakePostgres(t *testing.T) *fakePostgres {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	db := &fakePostgres{t: t, listener: listener}
+	go db.accept()
+
+	previous := config.DB
+	config.DB = pg.Connect(&pg.Options{
+		Addr:     listener.Addr().String(),
+		User:     "test",
+		Database: "test",
+		PoolSize: 4,
+	})
+	t.Cleanup(func() {
+		config.DB.Close()
+		config.DB = previous
+		listener.Close()
+
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		for _, query := range db.unexpected {
+			t.Errorf("unexpected query: %s", query)
+		}
+	})
+	return db
+}
+
+// Handle answers the statements containing fragment. Handlers registered later
+// take precedence.
+func (db *fakePostgres) Handle(fragment string, answer func(query string) fakeResult) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.handlers = append(db.handlers, fakeHandler{fragment: fragment, answer: answer})
+}
+
+// Queries returns the statements received so far that contain fragment
+func (db *fakePostgres) Queries(fragment string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var queries []string
+	for _, query := range db.queries {
+		if strings.Contains(query, fragment) {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
+
+func (db *fakePostgres) accept() {
+	for {
+		conn, err := db.listener.Accept()
+		if err != nil {
+			return
+		}
+		go db.serve(conn)
+	}
+}
+
+func (db *fakePostgres) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	wr := bufio.NewWriter(conn)
+
+	// Startup message: length, protocol version and parameters. Any user is
+	// accepted without a password.
+	var length int32
+	if err := binary.Read(rd, binary.BigEndian, &length); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, rd, int64(length-4)); err != nil {
+		return
+	}
+	writeFakeMessage(wr, 'R', fakeInt32(0))
+	writeFakeMessage(wr, 'Z', []byte{'I'})
+	if wr.Flush() != nil {
+		return
+	}
+
+	for {
+		typ, err := rd.ReadByte()
+		if err != nil {
+			return
+		}
+		if err := binary.Read(rd, binary.BigEndian, &length); err != nil {
+			return
+		}
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(rd, body); err != nil {
+			return
+		}
+
+		switch typ {
+		case 'Q':
+			db.reply(wr, string(bytes.TrimRight(body, "\x00")))
+		case 'X':
+			return
+		default:
+			db.mu.Lock()
+			db.unexpected = append(db.unexpected, fmt.Sprintf("message %q", typ))
+			db.mu.Unlock()
+			return
+		}
+		if wr.Flush() != nil {
+			return
+		}
+	}
+}
+
+// reply answers one statement
+func (db *fakePostgres) reply(wr *bufio.Writer, query string) {
+	res, ok := db.answer(query)
+	if !ok {
+		res = fakeResult{errCode: "XX000"}
+	}
+
+	switch {
+	case res.errCode != "":
+		var b bytes.Buffer
+		b.WriteString("SERROR\x00")
+		b.WriteString("C" + res.errCode + "\x00")
+		b.WriteString("Mfake error\x00\x00")
+		writeFakeMessage(wr, 'E', b.Bytes())
+	default:
+		if len(res.columns) > 0 {
+			var b bytes.Buffer
+			b.Write(fakeInt16(len(res.columns)))
+			for _, column := range res.columns {
+				b.WriteString(column + "\x00")
+				b.Write(fakeInt32(0))  // Table OID
+				b.Write(fakeInt16(0))  // Column number
+				b.Write(fakeInt32(25)) // Type OID: text
+				b.Write(fakeInt16(-1)) // Type size
+				b.Write(fakeInt32(-1)) // Type modifier
+				b.Write(fakeInt16(0))  // Text format
+			}
+			writeFakeMessage(wr, 'T', b.Bytes())
+		}
+		for _, row := range res.rows {
+			var b bytes.Buffer
+			b.Write(fakeInt16(len(row)))
+			for _, value := range row {
+				if value == nil {
+					b.Write(fakeInt32(-1))
+					continue
+				}
+				b.Write(fakeInt32(len(value)))
+				b.Write(value)
+			}
+			writeFakeMessage(wr, 'D', b.Bytes())
+		}
+
+		tag := res.tag
+		if tag == "" {
+			tag = "SELECT " + strconv.Itoa(len(res.rows))
+		}
+		writeFakeMessage(wr, 'C', []byte(tag+"\x00"))
+	}
+	writeFakeMessage(wr, 'Z', []byte{'I'})
+}
+
+// answer finds the handler of a statement. Transaction control always succeeds.
+func (db *fakePostgres) answer(query string) (fakeResult, bool) {
+	db.mu.Lock()
+	db.queries = append(db.queries, query)
+	var answer func(string) fakeResult
+	for i := len(db.handlers) - 1; i >= 0; i-- {
+		if strings.Contains(query, db.handlers[i].fragment) {
+			answer = db.handlers[i].answer
+			break
+		}
+	}
+	if answer == nil {
+		switch query {
+		case "BEGIN", "COMMIT", "ROLLBACK":
+			db.mu.Unlock()
+			return fakeResult{tag: query}, true
+		}
+		db.unexpected = append(db.unexpected, query)
+		db.mu.Unlock()
+		return fakeResult{}, false
+	}
+	db.mu.Unlock()
+
+	// Handlers run unlocked, they may block to let concurrent requests meet
+	return answer(query), true
+}
+
+// fakeRows encodes models (pointers to structs) as the rows of a SELECT
+func fakeRows(models ...interface{}) fakeResult {
+	res := fakeResult{}
+	for _, model := range models {
+		v := reflect.Indirect(reflect.ValueOf(model))
+		table := orm.GetTable(v.Type())
+
+		row := make([][]byte, len(table.Fields))
+		for i, field := range table.Fields {
+			if len(res.rows) == 0 {
+				res.columns = append(res.columns, field.SQLName)
+			}
+			row[i] = fakeText(field, field.Value(v))
+		}
+		res.rows = append(res.rows, row)
+	}
+	return res
+}
+
+// fakeCount answers a SELECT count(*)
+func fakeCount(n int) fakeResult {
+	return fakeResult{
+		columns: []string{"count"},
+		rows:    [][][]byte{{[]byte(strconv.Itoa(n))}},
+	}
+}
+
+// fakeTag answers a statement without rows, such as "UPDATE 1"
+func fakeTag(tag string) fakeResult {
+	return fakeResult{tag: tag}
+}
+
+// fakeValue matches one value of a VALUES list: a quoted string or a keyword
+var fakeValue = regexp.MustCompile(`'(?:[^']|'')*'|[^,\s()]+`)
+
+// fakeInsertedValues returns the column values of a single-row INSERT built
+// by go-pg, unquoted. NULL and DEFAULT values are left out.
+func fakeInsertedValues(query string) map[string]string {
+	start := strings.Index(query, "(")
+	end := strings.Index(query, ") VALUES (")
+	if start == -1 || end == -1 {
+		return nil
+	}
+
+	columns := strings.Split(query[start+1:end], ", ")
+	values := fakeValue.FindAllString(query[end+len(") VALUES ("):], len(columns))
+
+	inserted := map[string]string{}
+	for i, column := range columns {
+		if i >= len(values) || !strings.HasPrefix(values[i], "'") {
+			continue
+		}
+		value := strings.ReplaceAll(values[i][1:len(values[i])-1], "''", "'")
+		inserted[strings.Trim(column, `"`)] = value
+	}
+	return inserted
+}
+
+// fakeText encodes a field value in the PostgreSQL text format
+func fakeText(field *orm.Field, v reflect.Value) []byte {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return []byte(value.Format(time.RFC3339Nano))
+	case []byte:
+		if value == nil {
+			return nil
+		}
+		return []byte(`\x` + hex.EncodeToString(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte("t")
+		}
+		return []byte("f")
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return []byte(strconv.FormatInt(v.Int(), 10))
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+	}
+
+	if v.Kind() == reflect.Slice && strings.Contains(field.Field.Tag.Get("pg"), ",array") {
+		elements := make([]string, v.Len())
+		for i := range elements {
+			element := fmt.Sprint(v.Index(i).Interface())
+			element = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element)
+			elements[i] = `"` + element + `"`
+		}
+		return []byte("{" + strings.Join(elements, ",") + "}")
+	}
+
+	b, _ := json.Marshal(v.Interface())
+	return b
+}
+
+func writeFakeMessage(wr *bufio.Writer, typ byte, body []byte) {
+	wr.WriteByte(typ)
+	wr.Write(fakeInt32(len(body) + 4))
+	wr.Write(body)
+}
+
+func fakeInt16(n int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(n))
+}
+
+func fakeInt32(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
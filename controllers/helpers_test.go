@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/gofiber/fiber/v2"
+)
+
+// testPassword is the password of the users made by newTestUser
+const testPassword = "correct horse battery staple"
+
+// TestMain signs the tokens of the tests with a fixed HS256 key
+func TestMain(m *testing.M) {
+	auth.DefaultKeyRing.Replace(auth.NewHMACSigningKey("test", []byte("test signing secret")), nil)
+	os.Exit(m.Run())
+}
+
+// newTestRedis starts an in-memory Redis and points config.Redis at it until
+// the end of the test
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	previous := config.Redis
+	config.Redis = redis.NewRedisClient(server.Addr(), "", 0)
+	t.Cleanup(func() {
+		config.Redis.Close()
+		config.Redis = previous
+	})
+	return server
+}
+
+// newTestUser returns an active, verified user whose password is testPassword
+func newTestUser(t *testing.T) *models.User {
+	t.Helper()
+
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	now := time.Now()
+	return &models.User{
+		ID:            "6f1c2b1e-4d7a-4f0e-9c2a-3b8e5d9f1a20",
+		Email:         "user@example.com",
+		Password:      hash,
+		FirstName:     "Test",
+		LastName:      "User",
+		EmailVerified: true,
+		Status:        models.UserStatusActive,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+// asUser stands in for the JWT middleware, authenticating every request as userID
+func asUser(userID string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user_id", userID)
+		c.Locals("subject_type", "user")
+		return c.Next()
+	}
+}
+
+// doJSON sends body as JSON and decodes the JSON response
+func doJSON(t *testing.T, app *fiber.App, method, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return doRequest(t, app, req)
+}
+
+// doForm posts form, authenticated as the client when clientID is set, and
+// decodes the JSON response
+func doForm(t *testing.T, app *fiber.App, path string, form url.Values, clientID, secret string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	return doRequest(t, app, req)
+}
+
+func doRequest(t *testing.T, app *fiber.App, req *http.Request) (int, map[string]interface{}) {
+	t.Helper()
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("%s %s: decode response: %v", req.Method, req.URL.Path, err)
+	}
+	return resp.StatusCode, result
+}
@@ -0,0 +1,585 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// invitationTTL is how long an invite link stays valid
+var invitationTTL = time.Duration(config.GetEnvInt("INVITATION_TTL_HOURS", 72)) * time.Hour
+
+// invitationURL is the page the invite link points to. It should post the
+// token to /invitations/accept, with the new user's details when the address
+// has no account yet. Invitations are unavailable until it is set.
+var invitationURL = config.GetEnv("INVITATION_URL", "")
+
+// errInvalidInvitation is returned for an invite token that is invalid, expired,
+// replaced by a resend, revoked or already used
+var errInvalidInvitation = errors.New("invalid or expired invitation")
+
+// CreateInvitationRequest struct to capture the address and role to invite
+type CreateInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// InvitationTokenRequest struct to capture the token of an invite link
+type InvitationTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// AcceptInvitationRequest struct to capture the invite token and, for
+// addresses without an account, the details to sign up with
+type AcceptInvitationRequest struct {
+	Token     string `json:"token"`
+	Password  string `json:"password"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// CreateInvitation invites an address to the current organization and emails
+// the invite link (owners and admins). Only owners can invite owners.
+func CreateInvitation(c *fiber.Ctx) error {
+	if invitationURL == "" {
+		return linkNotConfigured(c, "INVITATION_URL")
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	var req CreateInvitationRequest
+	if err := c.BodyParser(&req); err != nil || !strings.Contains(req.Email, "@") {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	if !models.ValidOrgRole(req.Role) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "role must be one of " + strings.Join(models.OrgRoles, ", "),
+		})
+	}
+	if req.Role == models.OrgRoleOwner && orgRole != models.OrgRoleOwner {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Only owners can invite owners",
+		})
+	}
+	req.Email = strings.TrimSpace(req.Email)
+
+	members, err := config.DB.Model((*models.User)(nil)).
+		Join("JOIN memberships AS m ON m.user_id = ?TableAlias.id").
+		Where("m.org_id = ?", orgID).
+		Where("?TableAlias.email = ?", req.Email).
+		Count()
+	if err != nil {
+		log.Printf("Error querying organization members: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if members > 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "This address is already a member of the organization",
+		})
+	}
+
+	pending, err := config.DB.Model((*models.Invitation)(nil)).
+		Where("org_id = ?", orgID).
+		Where("lower(email) = lower(?)", req.Email).
+		Where("status = ?", models.InvitationPending).
+		Count()
+	if err != nil {
+		log.Printf("Error querying invitations: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if pending > 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "An invitation is already pending for this address, resend it instead",
+		})
+	}
+
+	now := time.Now()
+	invitation := &models.Invitation{
+		ID:        uuid.New().String(),
+		OrgID:     orgID,
+		Email:     req.Email,
+		Role:      req.Role,
+		InvitedBy: userID,
+		Status:    models.InvitationPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	token, err := newInvitationToken(invitation)
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(invitation).Insert(); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "org.invitation.create", "org", orgID, map[string]interface{}{
+			"invitation_id": invitation.ID,
+			"email":         invitation.Email,
+			"role":          invitation.Role,
+		})
+	})
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// The invitation exists even if the email fails, it can be resent
+	if err := sendInvitationEmail(c.Context(), c.Get(fiber.HeaderAcceptLanguage), invitation, token); err != nil {
+		log.Printf("Error sending invitation email: %v", err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(invitation)
+}
+
+// ListInvitations lists the invitations of the current organization, newest
+// first, optionally filtered by status (owners and admins)
+func ListInvitations(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+	now := time.Now()
+
+	invitations := []models.Invitation{}
+	q := config.DB.Model(&invitations).Where("org_id = ?", orgID).Order("created_at DESC")
+	switch status := c.Query("status"); status {
+	case "":
+	case models.InvitationPending:
+		q = q.Where("status = ?", status).Where("expires_at > ?", now)
+	case models.InvitationExpired:
+		q = q.Where("status = ?", models.InvitationPending).Where("expires_at <= ?", now)
+	case models.InvitationAccepted, models.InvitationRevoked:
+		q = q.Where("status = ?", status)
+	default:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "status must be one of pending, accepted, revoked, expired",
+		})
+	}
+
+	if err := q.Select(); err != nil {
+		log.Printf("Error querying invitations: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	for i := range invitations {
+		invitations[i].Status = invitations[i].CurrentStatus(now)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"invitations": invitations,
+	})
+}
+
+// ResendInvitation emails a new invite link for a pending or expired
+// invitation and restarts its expiry. Previous links stop working (owners and
+// admins, only owners for owner invitations).
+func ResendInvitation(c *fiber.Ctx) error {
+	if invitationURL == "" {
+		return linkNotConfigured(c, "INVITATION_URL")
+	}
+
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	invitation, err := findInvitation(orgID, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if invitation.Status != models.InvitationPending {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The invitation was already " + invitation.Status,
+		})
+	}
+	if invitation.Role == models.OrgRoleOwner && orgRole != models.OrgRoleOwner {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Only owners can invite owners",
+		})
+	}
+
+	token, err := newInvitationToken(invitation)
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	invitation.UpdatedAt = time.Now()
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(invitation).
+			Column("token_id", "expires_at", "updated_at").
+			WherePK().
+			Where("status = ?", models.InvitationPending).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return errInvalidInvitation
+		}
+		return recordAudit(tx, c, "org.invitation.resend", "org", orgID, map[string]interface{}{
+			"invitation_id": invitation.ID,
+			"email":         invitation.Email,
+		})
+	})
+	if err == errInvalidInvitation {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The invitation is no longer pending",
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := sendInvitationEmail(c.Context(), c.Get(fiber.HeaderAcceptLanguage), invitation, token); err != nil {
+		log.Printf("Error sending invitation email: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send the invitation email",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(invitation)
+}
+
+// RevokeInvitation revokes a pending or expired invitation, its link stops
+// working (owners and admins)
+func RevokeInvitation(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+
+	invitation, err := findInvitation(orgID, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Invitation not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	invitation.Status = models.InvitationRevoked
+	invitation.UpdatedAt = time.Now()
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(invitation).
+			Column("status", "updated_at").
+			WherePK().
+			Where("status = ?", models.InvitationPending).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return errInvalidInvitation
+		}
+		return recordAudit(tx, c, "org.invitation.revoke", "org", orgID, map[string]interface{}{
+			"invitation_id": invitation.ID,
+			"email":         invitation.Email,
+		})
+	})
+	if err == errInvalidInvitation {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The invitation is no longer pending",
+		})
+	}
+	if err != nil {
+		log.Printf("Error revoking invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Invitation revoked",
+	})
+}
+
+// GetInvitationDetails describes the invitation of an invite link, so the
+// invite page can show the organization and ask for sign-up details when the
+// address has no account yet
+func GetInvitationDetails(c *fiber.Ctx) error {
+	var req InvitationTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	invitation, err := validateInvitationToken(req.Token)
+	if err == errInvalidInvitation {
+		return invalidInvitationError(c)
+	}
+	if err != nil {
+		log.Printf("Error querying invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	org, err := findOrganization(invitation.OrgID)
+	if err != nil {
+		log.Printf("Error querying organization: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	exists, err := CheckEmailExists(config.DB, invitation.Email)
+	if err != nil {
+		log.Printf("Error checking email existence: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"organization": org.Name,
+		"email":        invitation.Email,
+		"role":         invitation.Role,
+		"expires_at":   invitation.ExpiresAt,
+		"has_account":  exists,
+	})
+}
+
+// AcceptInvitation accepts the invitation of an invite link. An existing user
+// with the invited address joins the organization. Otherwise the user signs up
+// with the password and name of the request, like SignUp, and the address
+// counts as verified since the link was sent to it.
+func AcceptInvitation(c *fiber.Ctx) error {
+	var req AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	invitation, err := validateInvitationToken(req.Token)
+	if err == errInvalidInvitation {
+		return invalidInvitationError(c)
+	}
+	if err != nil {
+		log.Printf("Error querying invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("email = ?", invitation.Email).Select()
+	if err == pg.ErrNoRows {
+		return signUp(c, SignUpRequest{
+			Email:     invitation.Email,
+			Password:  req.Password,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+		}, signUpOptions{
+			EmailVerified: true,
+			OnCreate: func(tx *pg.Tx, user *models.User) error {
+				return joinInvitedOrg(tx, c, invitation, user)
+			},
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if err := joinInvitedOrg(tx, c, invitation, &user); err != nil {
+			return err
+		}
+
+		// Following the link proved access to the mailbox
+		if user.EmailVerified {
+			return nil
+		}
+		now := time.Now()
+		_, err := tx.Model((*models.User)(nil)).
+			Set("email_verified = TRUE").
+			Set("email_verified_at = ?", now).
+			Set("updated_at = ?", now).
+			Where("id = ?", user.ID).
+			Where("email = ?", invitation.Email).
+			Update()
+		return err
+	})
+	if err == errInvalidInvitation {
+		return invalidInvitationError(c)
+	}
+	if err != nil {
+		log.Printf("Error accepting invitation: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Invitation accepted",
+		"org_id":  invitation.OrgID,
+	})
+}
+
+// joinInvitedOrg marks the invitation as accepted by the user and makes them a
+// member with the invited role. A user who is already a member keeps their
+// role. The invitation is claimed atomically, so a link only works once.
+func joinInvitedOrg(tx *pg.Tx, c *fiber.Ctx, invitation *models.Invitation, user *models.User) error {
+	now := time.Now()
+	res, err := tx.Model((*models.Invitation)(nil)).
+		Set("status = ?", models.InvitationAccepted).
+		Set("accepted_by = ?", user.ID).
+		Set("accepted_at = ?", now).
+		Set("updated_at = ?", now).
+		Where("id = ?", invitation.ID).
+		Where("token_id = ?", invitation.TokenID).
+		Where("status = ?", models.InvitationPending).
+		Where("expires_at > ?", now).
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return errInvalidInvitation
+	}
+
+	membership := &models.Membership{
+		OrgID:     invitation.OrgID,
+		UserID:    user.ID,
+		Role:      invitation.Role,
+		Status:    models.MembershipStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := tx.Model(membership).OnConflict("DO NOTHING").Insert(); err != nil {
+		return err
+	}
+
+	return recordAuditAs(tx, c, user.ID, "org.invitation.accept", "org", invitation.OrgID, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"user_id":       user.ID,
+		"role":          invitation.Role,
+	})
+}
+
+// validateInvitationToken checks the signature and expiry of an invite token
+// and returns its invitation, as long as the token is the latest one sent and
+// the invitation is still pending
+func validateInvitationToken(token string) (*models.Invitation, error) {
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeOrgInvite)
+	if err != nil {
+		return nil, errInvalidInvitation
+	}
+	invitationID, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+
+	var invitation models.Invitation
+	err = config.DB.Model(&invitation).Where("id = ?", invitationID).Select()
+	if err == pg.ErrNoRows {
+		return nil, errInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.TokenID != jti || invitation.CurrentStatus(time.Now()) != models.InvitationPending {
+		return nil, errInvalidInvitation
+	}
+	return &invitation, nil
+}
+
+// newInvitationToken signs a new invite token for the invitation and makes it
+// the only valid one, restarting the expiry. The caller saves the invitation.
+func newInvitationToken(invitation *models.Invitation) (string, error) {
+	token, jti, err := auth.GeneratePurposeToken(auth.PurposeOrgInvite, invitation.ID, map[string]interface{}{
+		"org_id": invitation.OrgID,
+	}, invitationTTL)
+	if err != nil {
+		return "", err
+	}
+
+	invitation.TokenID = jti
+	invitation.ExpiresAt = time.Now().Add(invitationTTL)
+	return token, nil
+}
+
+// sendInvitationEmail sends the invite link to the invited address
+func sendInvitationEmail(ctx context.Context, lang string, invitation *models.Invitation, token string) error {
+	org, err := findOrganization(invitation.OrgID)
+	if err != nil {
+		return err
+	}
+
+	inviter := ""
+	if user, err := findUserByID(invitation.InvitedBy); err == nil {
+		inviter = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		if inviter == "" {
+			inviter = user.Email
+		}
+	}
+
+	return sendMail(ctx, lang, invitation.Email, "org_invitation", map[string]interface{}{
+		"Organization":   org.Name,
+		"Inviter":        inviter,
+		"Role":           invitation.Role,
+		"Link":           invitationURL + "?token=" + url.QueryEscape(token),
+		"ExpiresInHours": int(invitationTTL.Hours()),
+	})
+}
+
+// findInvitation loads an invitation of an organization
+func findInvitation(orgID, invitationID string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := config.DB.Model(&invitation).Where("id = ?", invitationID).Where("org_id = ?", orgID).Select()
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// invalidInvitationError answers a request presenting an unusable invite token
+func invalidInvitationError(c *fiber.Ctx) error {
+	return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+		"error": "Invalid or expired invitation",
+	})
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/keystore"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CreateSigningKeyRequest struct to capture the key to generate
+type CreateSigningKeyRequest struct {
+	Algorithm string `json:"algorithm"`
+	Activate  bool   `json:"activate"`
+}
+
+// ListSigningKeys returns every stored signing key (without private material)
+func ListSigningKeys(c *fiber.Ctx) error {
+	keys, err := keystore.List(config.DB)
+	if err != nil {
+		log.Printf("Error listing signing keys: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"keys": keys,
+	})
+}
+
+// CreateSigningKey generates a new signing key, optionally promoting it right away
+func CreateSigningKey(c *fiber.Ctx) error {
+	var req CreateSigningKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = auth.AlgES256
+	}
+
+	switch req.Algorithm {
+	case auth.AlgRS256, auth.AlgES256, auth.AlgEdDSA:
+	default:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unsupported algorithm",
+		})
+	}
+
+	key, err := keystore.Generate(config.DB, req.Algorithm)
+	if err != nil {
+		log.Printf("Error generating signing key: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate signing key",
+		})
+	}
+
+	if req.Activate {
+		if err := keystore.Promote(config.DB, key.ID); err != nil {
+			log.Printf("Error promoting signing key: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to promote signing key",
+			})
+		}
+	}
+
+	reloadKeyRing()
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"kid":       key.ID,
+		"alg":       key.Algorithm,
+		"activated": req.Activate,
+	})
+}
+
+// PromoteSigningKey makes a pending key the active signing key and retires the previous one
+func PromoteSigningKey(c *fiber.Ctx) error {
+	err := keystore.Promote(config.DB, c.Params("kid"))
+	if err == keystore.ErrKeyNotFound {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Signing key not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error promoting signing key: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to promote signing key",
+		})
+	}
+
+	reloadKeyRing()
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Signing key promoted",
+	})
+}
+
+// PruneSigningKeys expires retired keys that are past their verification window
+func PruneSigningKeys(c *fiber.Ctx) error {
+	expired, err := keystore.Prune(config.DB)
+	if err != nil {
+		log.Printf("Error pruning signing keys: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	reloadKeyRing()
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"expired": expired,
+	})
+}
+
+// reloadKeyRing applies key changes to this instance immediately; other
+// instances pick them up on their next refresh
+func reloadKeyRing() {
+	if err := keystore.Load(config.DB); err != nil {
+		log.Printf("Error reloading signing keys: %v", err)
+	}
+}
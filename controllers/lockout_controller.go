@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// lockoutError answers a login attempt refused by the lockout policy
+func lockoutError(c *fiber.Ctx, err *auth.LockoutError) error {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	message := "Too many failed login attempts. Try again later."
+	if err.Locked {
+		message = "Account temporarily locked after too many failed login attempts. Try again later."
+	}
+
+	return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": retryAfter,
+	})
+}
+
+// verifyUserPassword checks the password of a user under the lockout policy:
+// guesses are refused while the account is locked or delayed for this client
+// (a *auth.LockoutError is returned), a wrong password counts as a failed login
+// (errInvalidCredentials) and a correct one resets the counters. needsRehash
+// reports a legacy or weaker hash to upgrade.
+func verifyUserPassword(c *fiber.Ctx, user *models.User, password string) (needsRehash bool, err error) {
+	lockout := auth.DefaultLockoutPolicy
+	if err := lockout.Check(c.Context(), config.Redis, user.ID, c.IP()); err != nil {
+		return false, err
+	}
+
+	// Verify the provided password against the stored hash (constant-time)
+	ok, needsRehash, err := auth.VerifyPassword(password, user.Password, user.Salt)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		locked, err := lockout.RecordFailure(c.Context(), config.Redis, user.ID, c.IP())
+		if err != nil {
+			log.Printf("Error recording failed login: %v", err)
+		}
+		if locked {
+			log.Printf("Account %s locked after repeated failed logins", user.ID)
+			if err := sendAccountLockedEmail(c.Context(), user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+				log.Printf("Error sending account locked email: %v", err)
+			}
+		}
+		return false, errInvalidCredentials
+	}
+
+	if err := lockout.RecordSuccess(c.Context(), config.Redis, user.ID, c.IP()); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+	return needsRehash, nil
+}
+
+// GetUserLockout returns the lockout state of a user (admin)
+func GetUserLockout(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	status, err := auth.DefaultLockoutPolicy.Status(c.Context(), config.Redis, user.ID)
+	if err != nil {
+		log.Printf("Error reading lockout status: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// UnlockUser lifts every lock and delay of a user and resets their failed login counters (admin)
+func UnlockUser(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := auth.DefaultLockoutPolicy.Unlock(c.Context(), config.Redis, user.ID); err != nil {
+		log.Printf("Error unlocking user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	log.Printf("Account %s unlocked by an administrator", user.ID)
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "User unlocked",
+	})
+}
+
+// sendAccountLockedEmail tells the user their account was locked, so they
+// know someone is guessing their password
+func sendAccountLockedEmail(ctx context.Context, user *models.User, lang string) error {
+	return sendMail(ctx, lang, user.Email, "account_locked", map[string]interface{}{
+		"Name":        user.FirstName,
+		"LockedUntil": time.Now().Add(auth.DefaultLockoutPolicy.LockDuration).UTC().Format("2006-01-02 15:04 MST"),
+	})
+}
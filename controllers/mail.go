@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mailFrom is the sender of every email
+var mailFrom = config.GetEnv("MAIL_FROM", "Auth Service <no-reply@localhost>")
+
+// errMailerNotConfigured is returned when config.InitMailer was not called
+var errMailerNotConfigured = errors.New("mailer not configured")
+
+// sendMail renders the named template in the language preferred by lang (a
+// locale or an Accept-Language header value) and queues it for delivery
+func sendMail(ctx context.Context, lang, to, name string, data interface{}) error {
+	if config.Mailer == nil || config.MailTemplates == nil {
+		return errMailerNotConfigured
+	}
+
+	msg, err := config.MailTemplates.Render(lang, name, data)
+	if err != nil {
+		return err
+	}
+
+	msg.From = mailFrom
+	msg.To = []string{to}
+	return config.Mailer.Send(ctx, msg)
+}
+
+// linkNotConfigured answers requests for an emailed link whose landing page
+// (setting, such as PASSWORD_RESET_URL) is not configured. There is no default
+// page: the link must open a frontend that posts the token.
+func linkNotConfigured(c *fiber.Ctx, setting string) error {
+	log.Printf("Error sending link: %s is not set", setting)
+	return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Not configured on this server",
+	})
+}
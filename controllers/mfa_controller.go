@@ -0,0 +1,543 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/webauthn"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+)
+
+// mfaIssuer is the account issuer shown in authenticator apps
+var mfaIssuer = config.GetEnv("MFA_ISSUER", "Auth Service")
+
+// mfaChallengeTTL is how long the user has to enter the second factor after the password
+const mfaChallengeTTL = 5 * time.Minute
+
+// mfaChallengeAttempts is how many codes can be tried per challenge
+const mfaChallengeAttempts = 5
+
+// recoveryCodeCount is how many recovery codes are generated at enrollment
+const recoveryCodeCount = 10
+
+// Errors returned by completeMFAChallenge
+var (
+	errInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	errInvalidMFACode      = errors.New("invalid MFA code")
+)
+
+// mfaChallenge is a login waiting for its second factor
+type mfaChallenge struct {
+	UserID string `json:"user_id"`
+}
+
+// secondFactor is the proof presented to complete an MFA challenge, one of a
+// TOTP code, a recovery code or a WebAuthn assertion
+type secondFactor struct {
+	Code         string                      `json:"code"`          // TOTP code
+	RecoveryCode string                      `json:"recovery_code"` // Alternative to the TOTP code
+	WebAuthn     *webauthn.AssertionResponse `json:"webauthn"`      // Answer to the options of /login/mfa/webauthn
+}
+
+// VerifyMFARequest struct to capture the second factor of a login
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	secondFactor
+}
+
+// EnrollTOTPRequest struct to capture the password confirming TOTP enrollment
+type EnrollTOTPRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// TOTPCodeRequest struct to capture a TOTP code
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPRequest struct to capture the password confirming TOTP removal
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+}
+
+// EnrollTOTP starts TOTP enrollment after confirming the current password: it
+// generates a new secret and returns it as an otpauth URI and QR code. The
+// factor is only enabled once a code from the authenticator app is confirmed
+// with ConfirmTOTP.
+func EnrollTOTP(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	email, _ := c.Locals("email").(string)
+
+	var req EnrollTOTPRequest
+	if err := c.BodyParser(&req); err != nil || req.CurrentPassword == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	user, err := findUserByID(userID)
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Re-authenticate: with a stolen access token alone, an attacker could
+	// enroll their own authenticator and lock the owner out. Guesses count
+	// towards the lockout like failed logins.
+	_, err = verifyUserPassword(c, user, req.CurrentPassword)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errInvalidCredentials {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	}
+	if err != nil {
+		log.Printf("Error verifying password: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	mfa, err := findUserMFA(userID)
+	if err != nil {
+		log.Printf("Error querying MFA settings: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if mfa.TOTPEnabled {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "TOTP is already enabled",
+		})
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	encrypted, err := auth.EncryptSecret([]byte(secret), []byte(userID))
+	if err != nil {
+		log.Printf("Error encrypting TOTP secret: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	now := time.Now()
+	mfa = &models.UserMFA{
+		UserID:     userID,
+		TOTPSecret: encrypted,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	_, err = config.DB.Model(mfa).
+		OnConflict("(user_id) DO UPDATE").
+		Set("totp_secret = EXCLUDED.totp_secret").
+		Set("updated_at = EXCLUDED.updated_at").
+		Insert()
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	uri := auth.TOTPURI(mfaIssuer, email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Printf("Error generating QR code: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their authenticator app works.
+// Users without recovery codes get them in the response, shown only this once.
+func ConfirmTOTP(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req TOTPCodeRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	mfa, err := findUserMFA(userID)
+	if err != nil {
+		log.Printf("Error querying MFA settings: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if mfa.TOTPEnabled {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "TOTP is already enabled",
+		})
+	}
+	if mfa.TOTPSecret == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Start TOTP enrollment first",
+		})
+	}
+
+	secret, err := auth.DecryptSecret(mfa.TOTPSecret, []byte(userID))
+	if err != nil {
+		log.Printf("Error decrypting TOTP secret: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	counter, ok := auth.VerifyTOTP(string(secret), req.Code, time.Now())
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+
+	now := time.Now()
+	mfa.TOTPEnabled = true
+	mfa.TOTPEnabledAt = &now
+	mfa.TOTPLastCounter = counter
+	mfa.UpdatedAt = now
+	_, err = config.DB.Model(mfa).
+		Column("totp_enabled", "totp_enabled_at", "totp_last_counter", "updated_at").
+		WherePK().
+		Update()
+	if err != nil {
+		log.Printf("Error enabling TOTP: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Users who registered a passkey first keep the codes they were given then
+	codes, err := ensureRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	response := fiber.Map{
+		"message": "TOTP enabled",
+	}
+	if codes != nil {
+		response["recovery_codes"] = codes
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// DisableTOTP removes TOTP after confirming the password. Passkeys and the
+// recovery codes are kept.
+func DisableTOTP(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req DisableTOTPRequest
+	if err := c.BodyParser(&req); err != nil || req.Password == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	user, err := findUserByID(userID)
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	_, err = verifyUserPassword(c, user, req.Password)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errInvalidCredentials {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Password is incorrect",
+		})
+	}
+	if err != nil {
+		log.Printf("Error verifying password: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Only TOTP goes: passkeys still require a second factor, and the recovery
+	// codes stand in for them
+	_, err = config.DB.Model((*models.UserMFA)(nil)).
+		Set("totp_secret = NULL").
+		Set("totp_enabled = FALSE").
+		Set("totp_enabled_at = NULL").
+		Set("updated_at = ?", time.Now()).
+		Where("user_id = ?", userID).
+		Update()
+	if err != nil {
+		log.Printf("Error disabling TOTP: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "TOTP disabled",
+	})
+}
+
+// VerifyMFA completes a login that returned mfa_required and issues the tokens
+func VerifyMFA(c *fiber.Ctx) error {
+	var req VerifyMFARequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "" && req.WebAuthn == nil) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	user, err := completeMFAChallenge(c, req.MFAToken, req.secondFactor)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errInvalidMFAChallenge {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token, please log in again",
+		})
+	}
+	if err == errInvalidMFACode {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	}
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	tokens, err := issueTokens(user, tokenOptions{})
+	if err == errUserDeactivated {
+		return accountDeactivatedError(c)
+	}
+	if err != nil {
+		log.Printf("Error generating tokens: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error generating token",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// startMFAChallenge records a login waiting for its second factor and returns
+// the challenge token
+func startMFAChallenge(c *fiber.Ctx, user *models.User) (string, error) {
+	token, jti, err := auth.GeneratePurposeToken(auth.PurposeMFAChallenge, user.ID, nil, mfaChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storeJSON(c.Context(), mfaChallengeKey(jti), mfaChallenge{UserID: user.ID}, mfaChallengeTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// completeMFAChallenge checks the second factor of a challenge and returns the
+// user. Wrong codes count as failed logins for the lockout policy, and a
+// challenge is dropped after too many wrong codes.
+func completeMFAChallenge(c *fiber.Ctx, token string, factor secondFactor) (*models.User, error) {
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeMFAChallenge)
+	if err != nil {
+		return nil, errInvalidMFAChallenge
+	}
+
+	jti, _ := claims["jti"].(string)
+	key := mfaChallengeKey(jti)
+
+	var challenge mfaChallenge
+	if err := loadJSON(c.Context(), key, &challenge); err != nil || challenge.UserID != claims["sub"] {
+		return nil, errInvalidMFAChallenge
+	}
+
+	lockout := auth.DefaultLockoutPolicy
+	if err := lockout.Check(c.Context(), config.Redis, challenge.UserID, c.IP()); err != nil {
+		return nil, err
+	}
+
+	attempts, err := config.Redis.Incr(c.Context(), key+":attempts", mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > mfaChallengeAttempts {
+		config.Redis.Delete(c.Context(), key)
+		return nil, errInvalidMFAChallenge
+	}
+
+	var ok bool
+	if factor.WebAuthn != nil {
+		ok, err = verifyWebAuthnSecondFactor(c.Context(), jti, challenge.UserID, factor.WebAuthn)
+	} else {
+		ok, err = verifySecondFactor(challenge.UserID, factor.Code, factor.RecoveryCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if _, err := lockout.RecordFailure(c.Context(), config.Redis, challenge.UserID, c.IP()); err != nil {
+			log.Printf("Error recording failed login: %v", err)
+		}
+		return nil, errInvalidMFACode
+	}
+
+	// Consume the challenge so the same login cannot be completed twice
+	if err := consumeJSON(c.Context(), key, &challenge); err != nil {
+		return nil, errInvalidMFAChallenge
+	}
+
+	user, err := findUserByID(challenge.UserID)
+	if err == pg.ErrNoRows {
+		return nil, errInvalidMFAChallenge
+	}
+	return user, err
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code
+func verifySecondFactor(userID, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		// Removing the hash from the array makes the code single-use, atomically
+		res, err := config.DB.Model((*models.UserMFA)(nil)).
+			Set("recovery_codes = array_remove(recovery_codes, ?)", auth.HashRecoveryCode(recoveryCode)).
+			Set("updated_at = ?", time.Now()).
+			Where("user_id = ?", userID).
+			Where("? = ANY(recovery_codes)", auth.HashRecoveryCode(recoveryCode)).
+			Update()
+		if err != nil {
+			return false, err
+		}
+		return res.RowsAffected() == 1, nil
+	}
+
+	mfa, err := findUserMFA(userID)
+	if err != nil || !mfa.TOTPEnabled {
+		return false, err
+	}
+
+	secret, err := auth.DecryptSecret(mfa.TOTPSecret, []byte(userID))
+	if err != nil {
+		return false, err
+	}
+
+	counter, ok := auth.VerifyTOTP(string(secret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Accept every time step only once, so an observed code cannot be replayed
+	res, err := config.DB.Model((*models.UserMFA)(nil)).
+		Set("totp_last_counter = ?", counter).
+		Where("user_id = ?", userID).
+		Where("totp_last_counter < ?", counter).
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// mfaMethods lists the second factors a user can complete a login with. It is
+// empty for users without TOTP or a passkey, who log in with the first factor
+// alone.
+func mfaMethods(userID string) ([]string, error) {
+	mfa, err := findUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := config.DB.Model((*models.WebAuthnCredential)(nil)).Where("user_id = ?", userID).Count()
+	if err != nil {
+		return nil, err
+	}
+	return mfa.Methods(count), nil
+}
+
+// ensureRecoveryCodes gives recovery codes to a user who has none left, so that
+// losing their authenticator does not lock them out. It returns the new codes
+// to show once, or nil when the user keeps the codes they already have.
+func ensureRecoveryCodes(userID string) ([]string, error) {
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	mfa := &models.UserMFA{
+		UserID:        userID,
+		RecoveryCodes: hashes,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	res, err := config.DB.Model(mfa).
+		OnConflict("(user_id) DO UPDATE").
+		Set("recovery_codes = EXCLUDED.recovery_codes").
+		Set("updated_at = EXCLUDED.updated_at").
+		Where("coalesce(cardinality(?TableAlias.recovery_codes), 0) = 0").
+		Insert()
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return codes, nil
+}
+
+// findUserMFA loads the MFA settings of a user. Users without any return an
+// empty (disabled) record.
+func findUserMFA(userID string) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+	err := config.DB.Model(&mfa).Where("user_id = ?", userID).Select()
+	if err == pg.ErrNoRows {
+		return &models.UserMFA{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// mfaChallengeKey is the Redis key of a pending MFA challenge
+func mfaChallengeKey(jti string) string {
+	return "mfa:challenge:" + jti
+}
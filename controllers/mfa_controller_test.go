@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mfaFixture serves a user, their MFA settings and their number of WebAuthn
+// credentials from a fake database
+type mfaFixture struct {
+	db   *fakePostgres
+	user *models.User
+
+	mu          sync.Mutex
+	mfa         *models.UserMFA // nil when the user has no MFA row
+	credentials int
+}
+
+func newMFAFixture(t *testing.T, mfa *models.UserMFA, credentials int) *mfaFixture {
+	newTestRedis(t)
+	f := &mfaFixture{
+		db:          newFakePostgres(t),
+		user:        newTestUser(t),
+		mfa:         mfa,
+		credentials: credentials,
+	}
+
+	f.db.Handle(`FROM "users"`, func(string) fakeResult {
+		return fakeRows(f.user)
+	})
+	f.db.Handle(`FROM "user_mfa"`, func(string) fakeResult {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.mfa == nil {
+			return fakeRows()
+		}
+		return fakeRows(f.mfa)
+	})
+	f.db.Handle(`FROM "webauthn_credentials"`, func(string) fakeResult {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return fakeCount(f.credentials)
+	})
+	return f
+}
+
+func (f *mfaFixture) app() *fiber.App {
+	app := fiber.New()
+	app.Post("/login", Login)
+	app.Post("/mfa/totp/enroll", asUser(f.user.ID), EnrollTOTP)
+	app.Delete("/mfa/totp", asUser(f.user.ID), DisableTOTP)
+	return app
+}
+
+// login logs in with the password and returns the second factors asked for,
+// failing the test when none is
+func (f *mfaFixture) login(t *testing.T, app *fiber.App) []interface{} {
+	t.Helper()
+
+	status, body := doJSON(t, app, http.MethodPost, "/login", LoginRequest{
+		Email:    f.user.Email,
+		Password: testPassword,
+	})
+	if status != http.StatusOK || body["mfa_required"] != true {
+		t.Fatalf("login = %d %v, want an MFA challenge", status, body)
+	}
+	if token, _ := body["mfa_token"].(string); token == "" {
+		t.Errorf("login returned no mfa_token: %v", body)
+	}
+	methods, _ := body["methods"].([]interface{})
+	return methods
+}
+
+func TestLoginChallengesPasskeyOnlyUser(t *testing.T) {
+	f := newMFAFixture(t, &models.UserMFA{
+		RecoveryCodes: []string{auth.HashRecoveryCode("abcde-fghij")},
+	}, 1)
+	f.mfa.UserID = f.user.ID
+
+	methods := f.login(t, f.app())
+	if want := []interface{}{"webauthn", "recovery_code"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods = %v, want %v", methods, want)
+	}
+}
+
+func TestLoginListsOnlyEnabledMethods(t *testing.T) {
+	f := newMFAFixture(t, nil, 0)
+	f.mfa = &models.UserMFA{UserID: f.user.ID, TOTPSecret: "secret", TOTPEnabled: true}
+
+	methods := f.login(t, f.app())
+	if want := []interface{}{"totp"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods = %v, want %v", methods, want)
+	}
+}
+
+func TestDisableTOTPKeepsPasskeys(t *testing.T) {
+	recoveryCodes := []string{auth.HashRecoveryCode("abcde-fghij")}
+	f := newMFAFixture(t, nil, 1)
+	f.mfa = &models.UserMFA{
+		UserID:        f.user.ID,
+		TOTPSecret:    "secret",
+		TOTPEnabled:   true,
+		RecoveryCodes: recoveryCodes,
+	}
+	f.db.Handle(`UPDATE "user_mfa"`, func(query string) fakeResult {
+		if strings.Contains(query, "recovery_codes") {
+			t.Errorf("DisableTOTP touched the recovery codes: %s", query)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.mfa.TOTPSecret = ""
+		f.mfa.TOTPEnabled = false
+		return fakeTag("UPDATE 1")
+	})
+	app := f.app()
+
+	methods := f.login(t, app)
+	if want := []interface{}{"totp", "webauthn", "recovery_code"}; !reflect.DeepEqual(methods, want) {
+		t.Fatalf("methods before = %v, want %v", methods, want)
+	}
+
+	status, body := doJSON(t, app, http.MethodDelete, "/mfa/totp", DisableTOTPRequest{Password: testPassword})
+	if status != http.StatusOK {
+		t.Fatalf("DELETE /mfa/totp = %d %v", status, body)
+	}
+	if queries := f.db.Queries(`DELETE FROM "user_mfa"`); len(queries) != 0 {
+		t.Errorf("DisableTOTP deleted the MFA row: %v", queries)
+	}
+
+	methods = f.login(t, app)
+	if want := []interface{}{"webauthn", "recovery_code"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("methods after = %v, want %v", methods, want)
+	}
+	if !reflect.DeepEqual(f.mfa.RecoveryCodes, recoveryCodes) {
+		t.Errorf("recovery codes = %v, want %v", f.mfa.RecoveryCodes, recoveryCodes)
+	}
+}
+
+func TestTOTPPasswordChecksCountTowardsLockout(t *testing.T) {
+	tests := []struct {
+		method, path string
+		body         func(password string) interface{}
+	}{
+		{http.MethodPost, "/mfa/totp/enroll", func(password string) interface{} {
+			return EnrollTOTPRequest{CurrentPassword: password}
+		}},
+		{http.MethodDelete, "/mfa/totp", func(password string) interface{} {
+			return DisableTOTPRequest{Password: password}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f := newMFAFixture(t, nil, 0)
+			app := f.app()
+
+			failures := auth.DefaultLockoutPolicy.FreeAttempts + 1
+			for i := 0; i < failures; i++ {
+				status, body := doJSON(t, app, tt.method, tt.path, tt.body("wrong password"))
+				if status != http.StatusForbidden {
+					t.Fatalf("attempt %d = %d %v, want 403", i+1, status, body)
+				}
+			}
+
+			status, body := doJSON(t, app, tt.method, tt.path, tt.body(testPassword))
+			if status != http.StatusTooManyRequests {
+				t.Errorf("after %d failures = %d %v, want 429", failures, status, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// introspectionCacheTTL is how long active introspection results are cached in
+// Redis (INTROSPECTION_CACHE_SECONDS, 0 disables caching). A token revoked in
+// the meantime may be reported as active for up to this long.
+var introspectionCacheTTL = time.Duration(config.GetEnvInt("INTROSPECTION_CACHE_SECONDS", 0)) * time.Second
+
+// oauthError writes an OAuth 2.0 error response (RFC 6749 section 5.2)
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	if code == "invalid_client" {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// Token implements the OAuth 2.0 token endpoint (RFC 6749 section 3.2) for the
+// authorization_code (with PKCE), refresh_token and client_credentials grants
+func Token(c *fiber.Ctx) error {
+	client, err := identifyClient(c)
+	if err != nil {
+		if err != errInvalidClient {
+			log.Printf("Error authenticating client: %v", err)
+			return oauthError(c, http.StatusInternalServerError, "server_error", "Internal server error")
+		}
+		return oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	var tokens fiber.Map
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		tokens, err = exchangeAuthorizationCode(c, client)
+	case "refresh_token":
+		tokens, err = rotateRefreshToken(c.FormValue("refresh_token"), client.ID)
+	case "client_credentials":
+		tokens, err = issueServiceToken(c, client)
+	default:
+		return oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+	}
+
+	if err == errUnauthorizedClient {
+		return oauthError(c, http.StatusBadRequest, "unauthorized_client", "The client is not allowed to use this grant type")
+	}
+	if err == errInvalidScope {
+		return oauthError(c, http.StatusBadRequest, "invalid_scope", "The requested scope is not allowed for this client")
+	}
+	if err == errInvalidGrant || err == errInvalidRefreshToken {
+		return oauthError(c, http.StatusBadRequest, "invalid_grant", "The grant is invalid, expired or was issued to another client")
+	}
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		return oauthError(c, http.StatusInternalServerError, "server_error", "Internal server error")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+	return c.Status(http.StatusOK).JSON(oauthTokenResponse(tokens))
+}
+
+// Token endpoint errors mapped to their RFC 6749 error codes
+var (
+	errInvalidGrant       = errors.New("invalid grant")
+	errInvalidScope       = errors.New("invalid scope")
+	errUnauthorizedClient = errors.New("unauthorized client")
+)
+
+// exchangeAuthorizationCode redeems an authorization code for tokens after
+// checking it was issued to this client and redirect URI and verifying PKCE
+func exchangeAuthorizationCode(c *fiber.Ctx, client *models.OAuthClient) (fiber.Map, error) {
+	grant, err := consumeAuthorizationCode(c.Context(), c.FormValue("code"))
+	if err == redis.ErrKeyNotFound {
+		return nil, errInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if grant.ClientID != client.ID {
+		return nil, errInvalidGrant
+	}
+	// redirect_uri is required only if the authorization request included it
+	// (RFC 6749 section 4.1.3), but it must match whenever it is sent
+	redirectURI := c.FormValue("redirect_uri")
+	if (grant.RedirectURISupplied || redirectURI != "") && grant.RedirectURI != redirectURI {
+		return nil, errInvalidGrant
+	}
+	if !auth.VerifyPKCE(c.FormValue("code_verifier"), grant.CodeChallenge) {
+		return nil, errInvalidGrant
+	}
+
+	user, err := findUserByID(grant.UserID)
+	if err == pg.ErrNoRows {
+		return nil, errInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := issueTokens(user, tokenOptions{
+		ClientID: client.ID,
+		Scope:    grant.Scope,
+		Nonce:    grant.Nonce,
+		AuthTime: grant.AuthTime,
+	})
+	if err == errUserDeactivated {
+		return nil, errInvalidGrant
+	}
+	return tokens, err
+}
+
+// issueServiceToken issues an access token to a confidential client acting on
+// its own behalf (client credentials grant, RFC 6749 section 4.4). No refresh
+// token is issued; the client simply authenticates again.
+func issueServiceToken(c *fiber.Ctx, client *models.OAuthClient) (fiber.Map, error) {
+	if client.Public {
+		return nil, errUnauthorizedClient
+	}
+
+	// Without an explicit scope the client gets every scope it is allowed
+	scope := normalizeScope(c.FormValue("scope"))
+	if scope == "" {
+		scope = strings.Join(client.AllowedScopes, " ")
+	}
+	if !client.AllowsScope(scope) {
+		return nil, errInvalidScope
+	}
+
+	accessToken, err := auth.GenerateServiceToken(client.ID, client.Audience, scope, auth.AccessTokenExpirationHours)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := fiber.Map{
+		"token":      accessToken,
+		"token_type": "Bearer",
+		"expires_in": auth.AccessTokenExpirationHours * 3600,
+	}
+	if scope != "" {
+		tokens["scope"] = scope
+	}
+	return tokens, nil
+}
+
+// oauthTokenResponse renames the access token to the RFC 6749 member name
+func oauthTokenResponse(tokens fiber.Map) fiber.Map {
+	tokens["access_token"] = tokens["token"]
+	delete(tokens, "token")
+	return tokens
+}
+
+// Introspect implements OAuth 2.0 token introspection (RFC 7662). The caller
+// authenticates with its client credentials and posts the token to inspect.
+func Introspect(c *fiber.Ctx) error {
+	client, err := authenticateClient(c)
+	if err != nil {
+		if err != errInvalidClient {
+			log.Printf("Error authenticating client: %v", err)
+			return oauthError(c, http.StatusInternalServerError, "server_error", "Internal server error")
+		}
+		return oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauthError(c, http.StatusBadRequest, "invalid_request", "Missing token parameter")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(introspectToken(c.Context(), client, token))
+}
+
+// introspectToken returns the introspection response for an access (JWT) or
+// refresh (opaque) token. Inactive or unknown tokens only yield active=false.
+func introspectToken(ctx context.Context, client *models.OAuthClient, token string) fiber.Map {
+	if strings.Count(token, ".") != 2 {
+		return introspectRefreshToken(client, token)
+	}
+
+	cacheKey := "introspect:" + auth.HashOpaqueToken(token)
+	if cached := cachedIntrospection(ctx, cacheKey); cached != nil {
+		return cached
+	}
+
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		return fiber.Map{"active": false}
+	}
+	if config.Redis != nil {
+		if err := checkAccessToken(ctx, *claims); err != nil {
+			if err != auth.ErrTokenRevoked && err != auth.ErrStaleAuthorization && err != auth.ErrUserDeactivated {
+				log.Printf("Error checking token status: %v", err)
+			}
+			return fiber.Map{"active": false}
+		}
+	}
+
+	result := accessTokenIntrospection(*claims)
+	cacheIntrospection(ctx, cacheKey, result, *claims)
+	return result
+}
+
+// checkAccessToken refuses the access tokens that TokenAuthMiddleware refuses
+// despite a valid signature: revoked tokens, tokens issued before a change of
+// the user's roles and tokens of deactivated users
+func checkAccessToken(ctx context.Context, claims jwt.MapClaims) error {
+	if err := auth.CheckRevoked(ctx, config.Redis, claims); err != nil {
+		return err
+	}
+	if err := auth.CheckAuthzVersion(ctx, config.Redis, claims); err != nil {
+		return err
+	}
+	return auth.CheckUserActive(ctx, config.Redis, claims)
+}
+
+// accessTokenIntrospection maps access token claims to the RFC 7662 response members
+func accessTokenIntrospection(claims jwt.MapClaims) fiber.Map {
+	result := fiber.Map{
+		"active":     true,
+		"token_type": "Bearer",
+	}
+
+	if userID, ok := claims["user_id"].(string); ok {
+		result["sub"] = userID
+	}
+	if email, ok := claims["email"].(string); ok {
+		result["username"] = email
+	}
+	for _, name := range []string{"sub", "exp", "iat", "nbf", "jti", "iss", "aud", "scope", "client_id"} {
+		if value, ok := claims[name]; ok {
+			result[name] = value
+		}
+	}
+
+	return result
+}
+
+// introspectRefreshToken reports whether an opaque refresh token is still
+// usable. Refresh tokens are only reported to the client they were issued to;
+// first-party refresh tokens are never reported.
+func introspectRefreshToken(client *models.OAuthClient, token string) fiber.Map {
+	var record models.RefreshToken
+	err := config.DB.Model(&record).Where("token_hash = ?", auth.HashOpaqueToken(token)).Select()
+	if err != nil || record.ClientID != client.ID || record.UsedAt != nil || record.RevokedAt != nil || record.ExpiresAt.Before(time.Now()) {
+		if err != nil && err != pg.ErrNoRows {
+			log.Printf("Error querying refresh token: %v", err)
+		}
+		return fiber.Map{"active": false}
+	}
+
+	// The refresh grant refuses deactivated users, whose tokens are not active either
+	user, err := findUserByID(record.UserID)
+	if err != nil || !user.Active() {
+		if err != nil && err != pg.ErrNoRows {
+			log.Printf("Error querying user: %v", err)
+		}
+		return fiber.Map{"active": false}
+	}
+
+	result := fiber.Map{
+		"active":     true,
+		"token_type": "refresh_token",
+		"sub":        record.UserID,
+		"client_id":  record.ClientID,
+		"exp":        record.ExpiresAt.Unix(),
+		"iat":        record.CreatedAt.Unix(),
+	}
+	if record.Scope != "" {
+		result["scope"] = record.Scope
+	}
+	return result
+}
+
+// cachedIntrospection returns a cached introspection result, if any
+func cachedIntrospection(ctx context.Context, key string) fiber.Map {
+	if introspectionCacheTTL <= 0 || config.Redis == nil {
+		return nil
+	}
+
+	value, err := config.Redis.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+
+	var result fiber.Map
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// cacheIntrospection stores an active result until the cache TTL or the token's expiry, whichever is first
+func cacheIntrospection(ctx context.Context, key string, result fiber.Map, claims jwt.MapClaims) {
+	if introspectionCacheTTL <= 0 || config.Redis == nil {
+		return
+	}
+
+	expiration := time.Now().Add(introspectionCacheTTL)
+	if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiration) {
+		expiration = time.Unix(int64(exp), 0)
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	if err := config.Redis.Set(ctx, key, value, expiration); err != nil {
+		log.Printf("Error caching introspection result: %v", err)
+	}
+}
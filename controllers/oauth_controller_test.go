@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// introspectionFixture serves a user, the calling client and one refresh
+// token from a fake database
+type introspectionFixture struct {
+	user         *models.User
+	refreshToken *models.RefreshToken
+	app          *fiber.App
+}
+
+const (
+	testClientID     = "gateway"
+	testClientSecret = "gateway secret"
+)
+
+func newIntrospectionFixture(t *testing.T) *introspectionFixture {
+	newTestRedis(t)
+	db := newFakePostgres(t)
+	f := &introspectionFixture{user: newTestUser(t)}
+
+	client := &models.OAuthClient{
+		ID:         testClientID,
+		Name:       "API gateway",
+		SecretHash: auth.HashOpaqueToken(testClientSecret),
+	}
+	db.Handle(`FROM "oauth_clients"`, func(string) fakeResult {
+		return fakeRows(client)
+	})
+	db.Handle(`FROM "users"`, func(string) fakeResult {
+		return fakeRows(f.user)
+	})
+	db.Handle(`FROM "refresh_tokens"`, func(string) fakeResult {
+		if f.refreshToken == nil {
+			return fakeRows()
+		}
+		return fakeRows(f.refreshToken)
+	})
+
+	f.app = fiber.New()
+	f.app.Post("/oauth/introspect", Introspect)
+	return f
+}
+
+func (f *introspectionFixture) introspect(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	status, body := doForm(t, f.app, "/oauth/introspect", url.Values{"token": {token}}, testClientID, testClientSecret)
+	if status != http.StatusOK {
+		t.Fatalf("introspect = %d %v", status, body)
+	}
+	return body
+}
+
+func TestIntrospectAccessToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(ctx context.Context, userID string, claims map[string]interface{}) error
+		active bool
+	}{
+		{"active", func(context.Context, string, map[string]interface{}) error {
+			return nil
+		}, true},
+		{"revoked", func(ctx context.Context, _ string, claims map[string]interface{}) error {
+			return auth.RevokeToken(ctx, config.Redis, claims)
+		}, false},
+		{"stale roles", func(ctx context.Context, userID string, _ map[string]interface{}) error {
+			return auth.SetAuthzVersion(ctx, config.Redis, userID, 2)
+		}, false},
+		{"deactivated user", func(ctx context.Context, userID string, _ map[string]interface{}) error {
+			return auth.MarkUserDeactivated(ctx, config.Redis, userID)
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIntrospectionFixture(t)
+
+			token, err := auth.GenerateTokenWithClaims(f.user.ID, f.user.Email, 1, map[string]interface{}{
+				auth.ClaimAuthzVersion: 1,
+			})
+			if err != nil {
+				t.Fatalf("GenerateTokenWithClaims: %v", err)
+			}
+			claims, err := auth.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if err := tt.setup(context.Background(), f.user.ID, *claims); err != nil {
+				t.Fatalf("setup: %v", err)
+			}
+
+			body := f.introspect(t, token)
+			if body["active"] != tt.active {
+				t.Errorf("introspect = %v, want active=%v", body, tt.active)
+			}
+			if tt.active && body["sub"] != f.user.ID {
+				t.Errorf("sub = %v, want %s", body["sub"], f.user.ID)
+			}
+		})
+	}
+}
+
+func TestIntrospectRefreshToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		status   string
+		active   bool
+	}{
+		{"issued to the caller", testClientID, models.UserStatusActive, true},
+		{"issued to another client", "another-client", models.UserStatusActive, false},
+		{"first-party", "", models.UserStatusActive, false},
+		{"deactivated user", testClientID, models.UserStatusDeactivated, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newIntrospectionFixture(t)
+			f.user.Status = tt.status
+
+			token, tokenHash, err := auth.GenerateOpaqueToken()
+			if err != nil {
+				t.Fatalf("GenerateOpaqueToken: %v", err)
+			}
+			f.refreshToken = models.NewRefreshToken(f.user.ID, "", tokenHash, time.Hour)
+			f.refreshToken.ClientID = tt.clientID
+			f.refreshToken.Scope = "openid"
+
+			body := f.introspect(t, token)
+			if body["active"] != tt.active {
+				t.Errorf("introspect = %v, want active=%v", body, tt.active)
+			}
+			if tt.active && (body["client_id"] != testClientID || body["scope"] != "openid") {
+				t.Errorf("introspect = %v, want client_id %q and scope openid", body, testClientID)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// supportedScopes are the OpenID Connect scopes this provider understands
+var supportedScopes = []string{"openid", "profile", "email"}
+
+// userClaims returns the user's claims granted by the space-delimited scope
+func userClaims(user *models.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{}
+
+	if auth.HasScope(scope, "profile") {
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+		claims["name"] = user.FirstName + " " + user.LastName
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if auth.HasScope(scope, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+
+	return claims
+}
+
+// UserInfo implements the OpenID Connect UserInfo endpoint. It returns the
+// claims of the token's user, filtered by the scopes granted to the token.
+func UserInfo(c *fiber.Ctx) error {
+	claims, _ := c.Locals("claims").(jwt.MapClaims)
+	scope, _ := claims["scope"].(string)
+
+	if !auth.HasScope(scope, "openid") {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="openid"`)
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error":             "insufficient_scope",
+			"error_description": "The access token was not granted the openid scope",
+		})
+	}
+
+	userID, _ := c.Locals("user_id").(string)
+	user, err := findUserByID(userID)
+	if err == pg.ErrNoRows {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid_token",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	response := fiber.Map{"sub": user.ID}
+	for name, value := range userClaims(user, scope) {
+		response[name] = value
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(response)
+}
@@ -0,0 +1,585 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Errors returned while scoping tokens and changing memberships
+var (
+	errNotOrgMember = errors.New("not a member of the organization")
+	errLastOwner    = errors.New("the organization must keep an owner")
+)
+
+// OrganizationRequest struct to capture the name of an organization
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+// UpdateMemberRequest struct to capture the new role of a member
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// organizationResponse is an organization together with the caller's role in it
+type organizationResponse struct {
+	models.Organization
+	Role string `json:"role"`
+}
+
+// orgMember is a user as listed among the members of an organization
+type orgMember struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Role      string    `json:"role"`
+	Status    string    `json:"status"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// CreateOrganization creates an organization owned by the logged-in user. Use
+// SwitchOrganization to get tokens scoped to it.
+func CreateOrganization(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req OrganizationRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	now := time.Now()
+	org := &models.Organization{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(req.Name),
+		CreatedBy: userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	membership := &models.Membership{
+		OrgID:     org.ID,
+		UserID:    userID,
+		Role:      models.OrgRoleOwner,
+		Status:    models.MembershipStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(org).Insert(); err != nil {
+			return err
+		}
+		if _, err := tx.Model(membership).Insert(); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "org.create", "org", org.ID, map[string]interface{}{
+			"name": org.Name,
+		})
+	})
+	if err != nil {
+		log.Printf("Error creating organization: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(organizationResponse{Organization: *org, Role: membership.Role})
+}
+
+// ListOrganizations lists the organizations the logged-in user is a member of,
+// leaving out suspended memberships
+func ListOrganizations(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var memberships []models.Membership
+	err := config.DB.Model(&memberships).
+		Where("user_id = ?", userID).
+		Where("status != ?", models.MembershipStatusSuspended).
+		Order("created_at ASC").
+		Select()
+	if err != nil {
+		log.Printf("Error querying memberships: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	response := make([]organizationResponse, 0, len(memberships))
+	for _, membership := range memberships {
+		org, err := findOrganization(membership.OrgID)
+		if err != nil {
+			log.Printf("Error querying organization: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		response = append(response, organizationResponse{Organization: *org, Role: membership.Role})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"organizations": response,
+	})
+}
+
+// SwitchOrganization issues a new token pair scoped to another organization
+// of the logged-in user
+func SwitchOrganization(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	user, err := findUserByID(userID)
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	tokens, err := issueTokens(user, tokenOptions{OrgID: c.Params("id")})
+	if err == errNotOrgMember {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error generating tokens: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error generating token",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// GetCurrentOrganization returns the organization the token is scoped to
+func GetCurrentOrganization(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	org, err := findOrganization(orgID)
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying organization: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(organizationResponse{Organization: *org, Role: orgRole})
+}
+
+// UpdateCurrentOrganization renames the organization the token is scoped to (owners and admins)
+func UpdateCurrentOrganization(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	var req OrganizationRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	org, err := findOrganization(orgID)
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Organization not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying organization: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	before := org.Name
+	org.Name = strings.TrimSpace(req.Name)
+	org.UpdatedAt = time.Now()
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(org).Column("name", "updated_at").WherePK().Update(); err != nil {
+			return err
+		}
+		return recordAudit(tx, c, "org.update", "org", org.ID, map[string]interface{}{
+			"before": map[string]interface{}{"name": before},
+			"after":  map[string]interface{}{"name": org.Name},
+		})
+	})
+	if err != nil {
+		log.Printf("Error updating organization: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(organizationResponse{Organization: *org, Role: orgRole})
+}
+
+// ListOrgMembers lists the members of the organization the token is scoped to
+func ListOrgMembers(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+
+	members := []orgMember{}
+	err := config.DB.Model((*models.User)(nil)).
+		ColumnExpr("?TableAlias.id, ?TableAlias.email, ?TableAlias.first_name, ?TableAlias.last_name").
+		ColumnExpr("m.role, m.status, m.created_at AS joined_at").
+		Join("JOIN memberships AS m ON m.user_id = ?TableAlias.id").
+		Where("m.org_id = ?", orgID).
+		Order("m.created_at ASC").
+		Select(&members)
+	if err != nil {
+		log.Printf("Error querying organization members: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"members": members,
+	})
+}
+
+// UpdateOrgMember changes the role of a member of the current organization
+// (owners and admins). Only owners can appoint or demote owners, and the last
+// owner cannot be demoted.
+func UpdateOrgMember(c *fiber.Ctx) error {
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	var req UpdateMemberRequest
+	if err := c.BodyParser(&req); err != nil || !models.ValidOrgRole(req.Role) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "role must be one of " + strings.Join(models.OrgRoles, ", "),
+		})
+	}
+
+	membership, err := findMembership(orgID, c.Params("user_id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if (membership.Role == models.OrgRoleOwner || req.Role == models.OrgRoleOwner) && orgRole != models.OrgRoleOwner {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Only owners can appoint or demote owners",
+		})
+	}
+	if membership.Role == req.Role {
+		return c.Status(http.StatusOK).JSON(membership)
+	}
+
+	before := membership.Role
+	membership.Role = req.Role
+	membership.UpdatedAt = time.Now()
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if before == models.OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, orgID, membership.UserID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Model(membership).Column("role", "updated_at").WherePK().Update(); err != nil {
+			return err
+		}
+
+		// The org_role claim of the member's tokens is now outdated
+		var err error
+		bumped, err = bumpAuthzVersions(tx, "id = ?", membership.UserID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, "org.member.update", "org", orgID, map[string]interface{}{
+			"user_id": membership.UserID,
+			"before":  before,
+			"after":   membership.Role,
+		})
+	})
+	if err == errLastOwner {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The organization must keep an owner",
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return c.Status(http.StatusOK).JSON(membership)
+}
+
+// RemoveOrgMember removes a member from the current organization. Owners and
+// admins can remove members (only owners can remove owners), and every member
+// can leave. The last owner cannot leave.
+func RemoveOrgMember(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	membership, err := findMembership(orgID, c.Params("user_id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Member not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if membership.UserID != userID {
+		if orgRole != models.OrgRoleOwner && orgRole != models.OrgRoleAdmin {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient organization role",
+			})
+		}
+		if membership.Role == models.OrgRoleOwner && orgRole != models.OrgRoleOwner {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error": "Only owners can remove owners",
+			})
+		}
+	}
+
+	err = removeMembership(c, membership)
+	if err == errLastOwner {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The organization must keep an owner",
+		})
+	}
+	if err != nil {
+		log.Printf("Error removing membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Member removed",
+	})
+}
+
+// removeMembership deletes a membership, unless it is the organization's last
+// owner (errLastOwner). The member's tokens scoped to the organization become
+// stale and its sessions cannot be refreshed.
+func removeMembership(c *fiber.Ctx, membership *models.Membership) error {
+	var bumped []models.User
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if membership.Role == models.OrgRoleOwner {
+			if err := ensureAnotherOwner(tx, membership.OrgID, membership.UserID); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Model(membership).WherePK().Delete(); err != nil {
+			return err
+		}
+		if err := revokeOrgSessions(tx, membership, &bumped); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, "org.member.remove", "org", membership.OrgID, map[string]interface{}{
+			"user_id": membership.UserID,
+			"role":    membership.Role,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	publishAuthzVersions(c.Context(), bumped)
+	return nil
+}
+
+// setMembershipStatus suspends or reactivates a membership. A suspended member
+// keeps their role but loses access to the organization like a removed one;
+// the last active owner cannot be suspended (errLastOwner).
+func setMembershipStatus(c *fiber.Ctx, membership *models.Membership, status string) error {
+	if membership.Active() == (status == models.MembershipStatusActive) {
+		return nil
+	}
+
+	now := time.Now()
+	membership.Status = status
+	membership.SuspendedAt = nil
+	if status == models.MembershipStatusSuspended {
+		membership.SuspendedAt = &now
+	}
+	membership.UpdatedAt = now
+
+	var bumped []models.User
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		action := "org.member.reactivate"
+		if status == models.MembershipStatusSuspended {
+			action = "org.member.suspend"
+			if membership.Role == models.OrgRoleOwner {
+				if err := ensureAnotherOwner(tx, membership.OrgID, membership.UserID); err != nil {
+					return err
+				}
+			}
+			if err := revokeOrgSessions(tx, membership, &bumped); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Model(membership).Column("status", "suspended_at", "updated_at").WherePK().Update(); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, action, "org", membership.OrgID, map[string]interface{}{
+			"user_id": membership.UserID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	publishAuthzVersions(c.Context(), bumped)
+	return nil
+}
+
+// revokeOrgSessions makes the member's tokens stale and revokes their sessions
+// scoped to the membership's organization. The users whose authorization
+// version was bumped are added to bumped, to publish after the commit.
+func revokeOrgSessions(tx *pg.Tx, membership *models.Membership, bumped *[]models.User) error {
+	users, err := bumpAuthzVersions(tx, "id = ?", membership.UserID)
+	if err != nil {
+		return err
+	}
+	*bumped = append(*bumped, users...)
+
+	_, err = tx.Model((*models.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("user_id = ?", membership.UserID).
+		Where("org_id = ?", membership.OrgID).
+		Where("revoked_at IS NULL").
+		Update()
+	return err
+}
+
+// ensureAnotherOwner returns errLastOwner unless the organization has an active
+// owner besides userID. The organization row is locked so concurrent changes cannot
+// both remove the other owner.
+func ensureAnotherOwner(tx *pg.Tx, orgID, userID string) error {
+	if _, err := tx.Exec("SELECT 1 FROM organizations WHERE id = ? FOR UPDATE", orgID); err != nil {
+		return err
+	}
+
+	owners, err := tx.Model((*models.Membership)(nil)).
+		Where("org_id = ?", orgID).
+		Where("role = ?", models.OrgRoleOwner).
+		Where("status != ?", models.MembershipStatusSuspended).
+		Where("user_id != ?", userID).
+		Count()
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// sessionMembership returns the membership first-party tokens are scoped to:
+// the one in orgID, or the user's oldest active membership when orgID is
+// empty. Users without any active membership get nil.
+func sessionMembership(userID, orgID string) (*models.Membership, error) {
+	if orgID != "" {
+		membership, err := findMembership(orgID, userID)
+		if err == pg.ErrNoRows || (err == nil && !membership.Active()) {
+			return nil, errNotOrgMember
+		}
+		return membership, err
+	}
+
+	var membership models.Membership
+	err := config.DB.Model(&membership).
+		Where("user_id = ?", userID).
+		Where("status != ?", models.MembershipStatusSuspended).
+		Order("created_at ASC").
+		Limit(1).
+		Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// findMembership loads the membership of a user in an organization
+func findMembership(orgID, userID string) (*models.Membership, error) {
+	var membership models.Membership
+	err := config.DB.Model(&membership).Where("org_id = ?", orgID).Where("user_id = ?", userID).Select()
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// findOrganization loads an organization by primary key
+func findOrganization(orgID string) (*models.Organization, error) {
+	var org models.Organization
+	if err := config.DB.Model(&org).Where("id = ?", orgID).Select(); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// scopeUsersToOrg restricts a query on users to the members of the
+// organization the caller's token is scoped to. Only the admin API token sees
+// every user; other callers without an organization (users without
+// memberships, older tokens, services, API keys without an organization) see
+// none.
+func scopeUsersToOrg(c *fiber.Ctx, q *orm.Query) *orm.Query {
+	if middlewares.IsAdmin(c) {
+		return q
+	}
+
+	orgID, _ := c.Locals("org_id").(string)
+	if orgID == "" {
+		return q.Where("FALSE")
+	}
+	return q.Where("?TableAlias.id IN (SELECT user_id FROM memberships WHERE org_id = ?)", orgID)
+}
+
+// findUserInScope loads a user by primary key among the users the caller may
+// see (see scopeUsersToOrg). Users outside the caller's organization are
+// reported as pg.ErrNoRows.
+func findUserInScope(c *fiber.Ctx, userID string) (*models.User, error) {
+	var user models.User
+	err := scopeUsersToOrg(c, config.DB.Model(&user).Where("?TableAlias.id = ?", userID)).Select()
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,351 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+var passwordResetTTL = time.Duration(config.GetEnvInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute
+
+// passwordResetLimit is how many reset emails can be requested per address per hour
+var passwordResetLimit = int64(config.GetEnvInt("PASSWORD_RESET_LIMIT", 3))
+
+// passwordResetURL is the page the reset link points to. It should show a form
+// posting the token and the new password to /password/reset. Password reset is
+// unavailable until it is set.
+var passwordResetURL = config.GetEnv("PASSWORD_RESET_URL", "")
+
+// pendingPasswordReset is what a reset token stands for until it is used
+type pendingPasswordReset struct {
+	UserID string `json:"user_id"`
+	// Fingerprint of the password hash when the token was issued, so the token
+	// stops working once the password has changed
+	PasswordFingerprint string `json:"password_fingerprint"`
+}
+
+// ForgotPasswordRequest struct to capture the address to send a reset link to
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest struct to capture the reset token and the new password
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword sends a password reset link. It always answers 200 whether or
+// not the address is registered (or throttled), to avoid account enumeration.
+func ForgotPassword(c *fiber.Ctx) error {
+	if passwordResetURL == "" {
+		return linkNotConfigured(c, "PASSWORD_RESET_URL")
+	}
+
+	var req ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	response := fiber.Map{
+		"message": "If the address is registered, a password reset email has been sent",
+	}
+
+	count, err := config.Redis.Incr(c.Context(), "password_reset:throttle:"+strings.ToLower(req.Email), time.Hour)
+	if err != nil {
+		log.Printf("Error throttling password reset email: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if count > passwordResetLimit {
+		return c.Status(http.StatusOK).JSON(response)
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("email = ?", req.Email).Select()
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusOK).JSON(response)
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := sendPasswordResetEmail(c.Context(), &user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		log.Printf("Error sending password reset email: %v", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// ResetPassword sets a new password with a token from a reset email. Every
+// session of the user is revoked and the user is notified of the change.
+func ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	if req.Token == "" || req.Password == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing required fields",
+		})
+	}
+
+	// The token is only consumed once the new password is accepted, so a
+	// password rejected by the policy does not burn the link
+	tokenKey := passwordResetKey(auth.HashOpaqueToken(req.Token))
+	var pending pendingPasswordReset
+	if err := loadJSON(c.Context(), tokenKey, &pending); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired reset token",
+		})
+	}
+
+	user, err := findUserByID(pending.UserID)
+	if err != nil && err != pg.ErrNoRows {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if err == pg.ErrNoRows || auth.HashOpaqueToken(user.Password) != pending.PasswordFingerprint {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired reset token",
+		})
+	}
+
+	violations, err := auth.DefaultPasswordPolicy.Check(req.Password, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		log.Printf("Error checking password policy: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if len(violations) > 0 {
+		return passwordPolicyError(c, violations)
+	}
+
+	// Consume the token so the link only works once
+	if err := consumeJSON(c.Context(), tokenKey, &pending); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired reset token",
+		})
+	}
+
+	if err := setPassword(user, req.Password); err != nil {
+		log.Printf("Error resetting password: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := revokeAllSessions(c.Context(), user.ID); err != nil {
+		log.Printf("Error revoking sessions after password reset: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := sendPasswordChangedEmail(c.Context(), user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		log.Printf("Error sending password changed email: %v", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Password reset successfully",
+	})
+}
+
+// ChangePasswordRequest struct to capture a password change by a logged-in user
+type ChangePasswordRequest struct {
+	CurrentPassword     string `json:"current_password"`
+	NewPassword         string `json:"new_password"`
+	LogoutOtherSessions bool   `json:"logout_other_sessions"`
+}
+
+// ChangePassword changes the password of the logged-in user, who must confirm
+// the current password (first-party tokens only, see RequireFirstParty). With logout_other_sessions every other session is
+// revoked and the current one continues with the token pair in the response.
+func ChangePassword(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing required fields",
+		})
+	}
+
+	user, err := findUserByID(userID)
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Re-authenticate: a stolen access token alone must not take over the
+	// account. Guesses count towards the lockout like failed logins.
+	_, err = verifyUserPassword(c, user, req.CurrentPassword)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errInvalidCredentials {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	}
+	if err != nil {
+		log.Printf("Error verifying password: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "New password must be different from the current password",
+		})
+	}
+
+	violations, err := auth.DefaultPasswordPolicy.Check(req.NewPassword, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		log.Printf("Error checking password policy: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if len(violations) > 0 {
+		return passwordPolicyError(c, violations)
+	}
+
+	if err := setPassword(user, req.NewPassword); err != nil {
+		log.Printf("Error changing password: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := sendPasswordChangedEmail(c.Context(), user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		log.Printf("Error sending password changed email: %v", err)
+	}
+
+	response := fiber.Map{
+		"message": "Password changed successfully",
+	}
+
+	// Revoke every session, then start a new one for the caller so only the
+	// current device stays logged in
+	if req.LogoutOtherSessions {
+		if err := revokeAllSessions(c.Context(), user.ID); err != nil {
+			log.Printf("Error revoking sessions after password change: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+
+		tokens, err := issueTokens(user, tokenOptions{})
+		if err != nil {
+			log.Printf("Error generating tokens: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Error generating token",
+			})
+		}
+		for name, value := range tokens {
+			response[name] = value
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// passwordPolicyError reports the password policy rules a password violates
+func passwordPolicyError(c *fiber.Ctx, violations []auth.PolicyViolation) error {
+	return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+		"error":      "Password does not meet the requirements",
+		"violations": violations,
+	})
+}
+
+// setPassword hashes and stores a new password for the user
+func setPassword(user *models.User, password string) error {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user.Password = hashedPassword
+	user.Salt = ""
+	user.UpdatedAt = time.Now()
+
+	_, err = config.DB.Model(user).Column("password", "salt", "updated_at").WherePK().Update()
+	return err
+}
+
+// sendPasswordResetEmail issues a single-use reset token, stored hashed, and
+// sends the reset link
+func sendPasswordResetEmail(ctx context.Context, user *models.User, lang string) error {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	pending := pendingPasswordReset{
+		UserID:              user.ID,
+		PasswordFingerprint: auth.HashOpaqueToken(user.Password),
+	}
+	if err := storeJSON(ctx, passwordResetKey(tokenHash), pending, passwordResetTTL); err != nil {
+		return err
+	}
+
+	return sendMail(ctx, lang, user.Email, "password_reset", map[string]interface{}{
+		"Name":             user.FirstName,
+		"Link":             passwordResetURL + "?token=" + url.QueryEscape(token),
+		"ExpiresInMinutes": int(passwordResetTTL.Minutes()),
+	})
+}
+
+// sendPasswordChangedEmail tells the user their password was changed, so an
+// unexpected change can be noticed
+func sendPasswordChangedEmail(ctx context.Context, user *models.User, lang string) error {
+	return sendMail(ctx, lang, user.Email, "password_changed", map[string]interface{}{
+		"Name":      user.FirstName,
+		"ChangedAt": user.UpdatedAt.UTC().Format("2006-01-02 15:04 MST"),
+	})
+}
+
+// passwordResetKey is the Redis key of an unused reset token (by hash)
+func passwordResetKey(tokenHash string) string {
+	return "password_reset:" + tokenHash
+}
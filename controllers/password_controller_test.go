@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestChangePasswordCountsTowardsLockout(t *testing.T) {
+	newTestRedis(t)
+	db := newFakePostgres(t)
+	user := newTestUser(t)
+	db.Handle(`FROM "users"`, func(string) fakeResult {
+		return fakeRows(user)
+	})
+
+	app := fiber.New()
+	app.Put("/me/password", asUser(user.ID), ChangePassword)
+
+	// The first failure past the free attempts delays the next attempt
+	failures := auth.DefaultLockoutPolicy.FreeAttempts + 1
+	for i := 0; i < failures; i++ {
+		status, body := doJSON(t, app, http.MethodPut, "/me/password", ChangePasswordRequest{
+			CurrentPassword: "wrong password",
+			NewPassword:     "a brand new passphrase",
+		})
+		if status != http.StatusForbidden {
+			t.Fatalf("attempt %d = %d %v, want 403", i+1, status, body)
+		}
+	}
+
+	// Even the right password has to wait for the delay
+	status, body := doJSON(t, app, http.MethodPut, "/me/password", ChangePasswordRequest{
+		CurrentPassword: testPassword,
+		NewPassword:     "a brand new passphrase",
+	})
+	if status != http.StatusTooManyRequests {
+		t.Errorf("after %d failures = %d %v, want 429", failures, status, body)
+	}
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// magicLinkTTL is how long a login link stays valid
+var magicLinkTTL = time.Duration(config.GetEnvInt("MAGIC_LINK_TTL_MINUTES", 15)) * time.Minute
+
+// magicLinkURL is the page the login link points to. It should post the token
+// to /login/magic-link/verify, so that mail scanners following links do not
+// use it up. Login links are unavailable until it is set.
+var magicLinkURL = config.GetEnv("MAGIC_LINK_URL", "")
+
+// loginOTPTTL is how long an emailed login code stays valid
+var loginOTPTTL = time.Duration(config.GetEnvInt("LOGIN_OTP_TTL_MINUTES", 10)) * time.Minute
+
+// loginOTPAttempts is how many codes can be tried before the code is dropped
+var loginOTPAttempts = int64(config.GetEnvInt("LOGIN_OTP_ATTEMPTS", 5))
+
+// passwordlessEmailLimit is how many login links and codes, together, can be
+// requested per address per hour
+var passwordlessEmailLimit = int64(config.GetEnvInt("PASSWORDLESS_EMAIL_LIMIT", 5))
+
+// loginOTPDigits is the length of emailed login codes
+const loginOTPDigits = 6
+
+// errInvalidLoginCode is returned by verifyLoginOTP for a wrong, expired or exhausted code
+var errInvalidLoginCode = errors.New("invalid or expired login code")
+
+// pendingMagicLink is what a login link token stands for until it is used
+type pendingMagicLink struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// pendingLoginOTP is the login code last sent to an address
+type pendingLoginOTP struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	CodeHash string `json:"code_hash"`
+}
+
+// PasswordlessLoginRequest struct to capture the address to send a login link or code to
+type PasswordlessLoginRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyMagicLinkRequest struct to capture the token of a login link
+type VerifyMagicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyLoginOTPRequest struct to capture an emailed login code
+type VerifyLoginOTPRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// RequestMagicLink emails a single-use login link. It always answers 200
+// whether or not the address is registered (or throttled), to avoid account
+// enumeration.
+func RequestMagicLink(c *fiber.Ctx) error {
+	if magicLinkURL == "" {
+		return linkNotConfigured(c, "MAGIC_LINK_URL")
+	}
+	return requestPasswordlessLogin(c, "If the address is registered, a login link has been sent", sendMagicLinkEmail)
+}
+
+// RequestLoginOTP emails a 6-digit login code, replacing any code sent before.
+// It always answers 200 whether or not the address is registered (or throttled).
+func RequestLoginOTP(c *fiber.Ctx) error {
+	return requestPasswordlessLogin(c, "If the address is registered, a login code has been sent", sendLoginOTPEmail)
+}
+
+// VerifyMagicLink logs in with the token of a login link and answers like Login
+func VerifyMagicLink(c *fiber.Ctx) error {
+	var req VerifyMagicLinkRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	// Consume the token so the link only works once
+	var pending pendingMagicLink
+	if err := consumeJSON(c.Context(), magicLinkKey(auth.HashOpaqueToken(req.Token)), &pending); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired login link",
+		})
+	}
+
+	return completePasswordlessLogin(c, pending.UserID, pending.Email)
+}
+
+// VerifyLoginOTP logs in with an emailed login code and answers like Login.
+// Wrong codes count as failed logins for the lockout policy.
+func VerifyLoginOTP(c *fiber.Ctx) error {
+	var req VerifyLoginOTPRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" || req.Code == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	pending, err := verifyLoginOTP(c, req.Email, req.Code)
+	var lockoutErr *auth.LockoutError
+	if errors.As(err, &lockoutErr) {
+		return lockoutError(c, lockoutErr)
+	}
+	if err == errInvalidLoginCode {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired code",
+		})
+	}
+	if err != nil {
+		log.Printf("Error verifying login code: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return completePasswordlessLogin(c, pending.UserID, pending.Email)
+}
+
+// requestPasswordlessLogin throttles the address and sends the login email to registered users
+func requestPasswordlessLogin(c *fiber.Ctx, message string, send func(context.Context, *models.User, string) error) error {
+	var req PasswordlessLoginRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	response := fiber.Map{
+		"message": message,
+	}
+
+	count, err := config.Redis.Incr(c.Context(), "passwordless:throttle:"+strings.ToLower(req.Email), time.Hour)
+	if err != nil {
+		log.Printf("Error throttling login email: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if count > passwordlessEmailLimit {
+		return c.Status(http.StatusOK).JSON(response)
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("email = ?", req.Email).Select()
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusOK).JSON(response)
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err := send(c.Context(), &user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		log.Printf("Error sending login email: %v", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// verifyLoginOTP checks a login code against the one last sent to the address
+// and consumes it. The code is dropped after too many wrong attempts.
+func verifyLoginOTP(c *fiber.Ctx, email, code string) (*pendingLoginOTP, error) {
+	key := loginOTPKey(email)
+
+	var pending pendingLoginOTP
+	if err := loadJSON(c.Context(), key, &pending); err != nil {
+		return nil, errInvalidLoginCode
+	}
+
+	lockout := auth.DefaultLockoutPolicy
+	if err := lockout.Check(c.Context(), config.Redis, pending.UserID, c.IP()); err != nil {
+		return nil, err
+	}
+
+	attempts, err := config.Redis.Incr(c.Context(), key+":attempts", loginOTPTTL)
+	if err != nil {
+		return nil, err
+	}
+	if attempts > loginOTPAttempts {
+		config.Redis.Delete(c.Context(), key)
+		return nil, errInvalidLoginCode
+	}
+
+	codeHash := loginOTPHash(pending.UserID, code)
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(pending.CodeHash)) != 1 {
+		if _, err := lockout.RecordFailure(c.Context(), config.Redis, pending.UserID, c.IP()); err != nil {
+			log.Printf("Error recording failed login: %v", err)
+		}
+		return nil, errInvalidLoginCode
+	}
+
+	// Consume the code so it only works once, unless a new one replaced it meanwhile
+	if err := consumeJSON(c.Context(), key, &pending); err != nil || pending.CodeHash != codeHash {
+		return nil, errInvalidLoginCode
+	}
+	config.Redis.Delete(c.Context(), key+":attempts")
+
+	return &pending, nil
+}
+
+// completePasswordlessLogin logs in the user who proved access to their
+// mailbox. The email counts as verified from then on, as long as it is still
+// the address the link or code was sent to.
+func completePasswordlessLogin(c *fiber.Ctx, userID, email string) error {
+	user, err := findUserByID(userID)
+	if err == pg.ErrNoRows || (err == nil && user.Email != email) {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired login link or code",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if !user.EmailVerified {
+		now := time.Now()
+		_, err := config.DB.Model((*models.User)(nil)).
+			Set("email_verified = TRUE").
+			Set("email_verified_at = ?", now).
+			Set("updated_at = ?", now).
+			Where("id = ?", user.ID).
+			Where("email = ?", email).
+			Update()
+		if err != nil {
+			log.Printf("Error verifying email: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := auth.DefaultLockoutPolicy.RecordSuccess(c.Context(), config.Redis, user.ID, c.IP()); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+
+	return completeLogin(c, user)
+}
+
+// sendMagicLinkEmail issues a single-use login token, stored hashed, and sends the login link
+func sendMagicLinkEmail(ctx context.Context, user *models.User, lang string) error {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	pending := pendingMagicLink{UserID: user.ID, Email: user.Email}
+	if err := storeJSON(ctx, magicLinkKey(tokenHash), pending, magicLinkTTL); err != nil {
+		return err
+	}
+
+	return sendMail(ctx, lang, user.Email, "magic_link", map[string]interface{}{
+		"Name":             user.FirstName,
+		"Link":             magicLinkURL + "?token=" + url.QueryEscape(token),
+		"ExpiresInMinutes": int(magicLinkTTL.Minutes()),
+	})
+}
+
+// sendLoginOTPEmail generates a login code, stores its hash in place of any
+// previous code for the address and sends it
+func sendLoginOTPEmail(ctx context.Context, user *models.User, lang string) error {
+	code, err := auth.GenerateNumericCode(loginOTPDigits)
+	if err != nil {
+		return err
+	}
+
+	key := loginOTPKey(user.Email)
+	pending := pendingLoginOTP{UserID: user.ID, Email: user.Email, CodeHash: loginOTPHash(user.ID, code)}
+	if err := storeJSON(ctx, key, pending, loginOTPTTL); err != nil {
+		return err
+	}
+	if err := config.Redis.Delete(ctx, key+":attempts"); err != nil {
+		return err
+	}
+
+	return sendMail(ctx, lang, user.Email, "login_code", map[string]interface{}{
+		"Name":             user.FirstName,
+		"Code":             code,
+		"ExpiresInMinutes": int(loginOTPTTL.Minutes()),
+	})
+}
+
+// loginOTPHash hashes a login code bound to its user
+func loginOTPHash(userID, code string) string {
+	return auth.HashOpaqueToken(userID + ":" + strings.TrimSpace(code))
+}
+
+// magicLinkKey is the Redis key of an unused login link token (by hash)
+func magicLinkKey(tokenHash string) string {
+	return "magic_link:" + tokenHash
+}
+
+// loginOTPKey is the Redis key of the login code last sent to an address
+func loginOTPKey(email string) string {
+	return "login_otp:" + strings.ToLower(email)
+}
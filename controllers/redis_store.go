@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/config"
+)
+
+// storeJSON stores a value as JSON in Redis for the given duration
+func storeJSON(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return config.Redis.Set(ctx, key, data, time.Now().Add(ttl))
+}
+
+// loadJSON reads a JSON value stored with storeJSON
+func loadJSON(ctx context.Context, key string, value interface{}) error {
+	data, err := config.Redis.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), value)
+}
+
+// consumeJSON reads and deletes a JSON value stored with storeJSON, so it can
+// only be used once
+func consumeJSON(ctx context.Context, key string, value interface{}) error {
+	data, err := config.Redis.GetDel(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), value)
+}
@@ -0,0 +1,758 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// authzNamePattern is the format of role and permission names. They end up in
+// space-delimited scopes, so they cannot contain spaces.
+var authzNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,63}$`)
+
+// errUnknownPermission is returned when a role is created with a permission that does not exist
+var errUnknownPermission = errors.New("unknown permission")
+
+// CreateRoleRequest struct to capture a new role
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"` // Names of the permissions granted
+}
+
+// UpdateRoleRequest struct to capture changes to a role, omitted fields are kept
+type UpdateRoleRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// CreatePermissionRequest struct to capture a new permission
+type CreatePermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// roleResponse is a role together with the names of its permissions
+type roleResponse struct {
+	models.Role
+	Permissions []string `json:"permissions"`
+}
+
+// ListRoles lists every role with its permissions
+func ListRoles(c *fiber.Ctx) error {
+	roles := []models.Role{}
+	if err := config.DB.Model(&roles).Order("name ASC").Select(); err != nil {
+		log.Printf("Error querying roles: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	var grants []struct {
+		RoleID string
+		Name   string
+	}
+	err := config.DB.Model((*models.Permission)(nil)).
+		ColumnExpr("rp.role_id, permission.name").
+		Join("JOIN role_permissions AS rp ON rp.permission_id = permission.id").
+		Order("permission.name ASC").
+		Select(&grants)
+	if err != nil {
+		log.Printf("Error querying role permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	permissions := map[string][]string{}
+	for _, grant := range grants {
+		permissions[grant.RoleID] = append(permissions[grant.RoleID], grant.Name)
+	}
+
+	response := make([]roleResponse, 0, len(roles))
+	for _, role := range roles {
+		names := permissions[role.ID]
+		if names == nil {
+			names = []string{}
+		}
+		response = append(response, roleResponse{Role: role, Permissions: names})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"roles": response,
+	})
+}
+
+// GetRole returns a role, by ID or name, with its permissions
+func GetRole(c *fiber.Ctx) error {
+	role, err := findRole(c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return roleDetails(c, http.StatusOK, role)
+}
+
+// CreateRole creates a role granting the given permissions
+func CreateRole(c *fiber.Ctx) error {
+	var req CreateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if !authzNamePattern.MatchString(req.Name) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role names are lowercase letters, digits and _ . : - (at most 64 characters)",
+		})
+	}
+
+	now := time.Now()
+	role := &models.Role{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	conflict := false
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(role).OnConflict("(name) DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			conflict = true
+			return nil
+		}
+
+		for _, name := range req.Permissions {
+			var permission models.Permission
+			err := tx.Model(&permission).Where("name = ?", name).Select()
+			if err == pg.ErrNoRows {
+				return errUnknownPermission
+			}
+			if err != nil {
+				return err
+			}
+
+			grant := &models.RolePermission{RoleID: role.ID, PermissionID: permission.ID, CreatedAt: now}
+			if _, err := tx.Model(grant).OnConflict("DO NOTHING").Insert(); err != nil {
+				return err
+			}
+		}
+
+		return recordAudit(tx, c, "role.create", "role", role.ID, map[string]interface{}{
+			"name":        role.Name,
+			"description": role.Description,
+			"permissions": req.Permissions,
+		})
+	})
+	if err == errUnknownPermission {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unknown permission",
+		})
+	}
+	if err != nil {
+		log.Printf("Error creating role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if conflict {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Role already exists",
+		})
+	}
+
+	return roleDetails(c, http.StatusCreated, role)
+}
+
+// UpdateRole renames a role or changes its description. Renaming changes the
+// roles claim, so the tokens of the role's users become stale.
+func UpdateRole(c *fiber.Ctx) error {
+	var req UpdateRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if req.Name != nil && !authzNamePattern.MatchString(*req.Name) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role names are lowercase letters, digits and _ . : - (at most 64 characters)",
+		})
+	}
+
+	role, err := findRole(c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	renamed := req.Name != nil && *req.Name != role.Name
+	if renamed && role.Name == models.RoleAdmin {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The admin role cannot be renamed",
+		})
+	}
+
+	before := map[string]interface{}{"name": role.Name, "description": role.Description}
+	if req.Name != nil {
+		role.Name = *req.Name
+	}
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	role.UpdatedAt = time.Now()
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		if _, err := tx.Model(role).Column("name", "description", "updated_at").WherePK().Update(); err != nil {
+			return err
+		}
+
+		if renamed {
+			var err error
+			bumped, err = bumpAuthzVersions(tx, "id IN (SELECT user_id FROM user_roles WHERE role_id = ?)", role.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return recordAudit(tx, c, "role.update", "role", role.ID, map[string]interface{}{
+			"before": before,
+			"after":  map[string]interface{}{"name": role.Name, "description": role.Description},
+		})
+	})
+	if pgErr, ok := err.(pg.Error); ok && pgErr.IntegrityViolation() {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Role already exists",
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return roleDetails(c, http.StatusOK, role)
+}
+
+// DeleteRole deletes a role, unassigning it from every user. The admin role
+// cannot be deleted.
+func DeleteRole(c *fiber.Ctx) error {
+	role, err := findRole(c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if role.Name == models.RoleAdmin {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The admin role cannot be deleted",
+		})
+	}
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		var err error
+		bumped, err = bumpAuthzVersions(tx, "id IN (SELECT user_id FROM user_roles WHERE role_id = ?)", role.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Model((*models.UserRole)(nil)).Where("role_id = ?", role.ID).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model((*models.RolePermission)(nil)).Where("role_id = ?", role.ID).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model(role).WherePK().Delete(); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, "role.delete", "role", role.ID, map[string]interface{}{
+			"name":           role.Name,
+			"users_affected": len(bumped),
+		})
+	})
+	if err != nil {
+		log.Printf("Error deleting role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Role deleted",
+	})
+}
+
+// AddRolePermission grants a permission to a role
+func AddRolePermission(c *fiber.Ctx) error {
+	return changeRolePermission(c, true)
+}
+
+// RemoveRolePermission revokes a permission from a role
+func RemoveRolePermission(c *fiber.Ctx) error {
+	return changeRolePermission(c, false)
+}
+
+// changeRolePermission grants or revokes a permission of a role. The tokens
+// of the role's users become stale when the grant actually changes.
+func changeRolePermission(c *fiber.Ctx, grant bool) error {
+	role, err := findRole(c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	permission, err := findPermission(c.Params("permission"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Permission not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying permission: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		record := &models.RolePermission{RoleID: role.ID, PermissionID: permission.ID, CreatedAt: time.Now()}
+		action := "role.permission.add"
+
+		var res orm.Result
+		var err error
+		if grant {
+			res, err = tx.Model(record).OnConflict("DO NOTHING").Insert()
+		} else {
+			action = "role.permission.remove"
+			res, err = tx.Model(record).WherePK().Delete()
+		}
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+
+		bumped, err = bumpAuthzVersions(tx, "id IN (SELECT user_id FROM user_roles WHERE role_id = ?)", role.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, action, "role", role.ID, map[string]interface{}{
+			"role":       role.Name,
+			"permission": permission.Name,
+		})
+	})
+	if err != nil {
+		log.Printf("Error changing role permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return roleDetails(c, http.StatusOK, role)
+}
+
+// ListPermissions lists every permission
+func ListPermissions(c *fiber.Ctx) error {
+	permissions := []models.Permission{}
+	if err := config.DB.Model(&permissions).Order("name ASC").Select(); err != nil {
+		log.Printf("Error querying permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"permissions": permissions,
+	})
+}
+
+// CreatePermission creates a permission that roles can grant and routes can require
+func CreatePermission(c *fiber.Ctx) error {
+	var req CreatePermissionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if !authzNamePattern.MatchString(req.Name) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Permission names are lowercase letters, digits and _ . : - (at most 64 characters)",
+		})
+	}
+
+	permission := &models.Permission{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   time.Now(),
+	}
+
+	conflict := false
+	err := config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		res, err := tx.Model(permission).OnConflict("(name) DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			conflict = true
+			return nil
+		}
+
+		return recordAudit(tx, c, "permission.create", "permission", permission.ID, map[string]interface{}{
+			"name":        permission.Name,
+			"description": permission.Description,
+		})
+	})
+	if err != nil {
+		log.Printf("Error creating permission: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if conflict {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Permission already exists",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(permission)
+}
+
+// DeletePermission deletes a permission, revoking it from every role. Built-in
+// permissions cannot be deleted.
+func DeletePermission(c *fiber.Ctx) error {
+	permission, err := findPermission(c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Permission not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying permission: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	for _, builtin := range models.BuiltinPermissions {
+		if permission.Name == builtin {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "Built-in permissions cannot be deleted",
+			})
+		}
+	}
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		var err error
+		bumped, err = bumpAuthzVersions(tx, `id IN (SELECT ur.user_id FROM user_roles AS ur
+			JOIN role_permissions AS rp ON rp.role_id = ur.role_id WHERE rp.permission_id = ?)`, permission.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Model((*models.RolePermission)(nil)).Where("permission_id = ?", permission.ID).Delete(); err != nil {
+			return err
+		}
+		if _, err := tx.Model(permission).WherePK().Delete(); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, "permission.delete", "permission", permission.ID, map[string]interface{}{
+			"name":           permission.Name,
+			"users_affected": len(bumped),
+		})
+	})
+	if err != nil {
+		log.Printf("Error deleting permission: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Permission deleted",
+	})
+}
+
+// ListUserRoles lists the roles assigned to a user
+func ListUserRoles(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	roles := []models.Role{}
+	err = config.DB.Model(&roles).
+		Join("JOIN user_roles AS ur ON ur.role_id = role.id").
+		Where("ur.user_id = ?", user.ID).
+		Order("role.name ASC").
+		Select()
+	if err != nil {
+		log.Printf("Error querying user roles: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"roles": roles,
+	})
+}
+
+// GetUserPermissions returns the effective permissions of a user, granted by
+// all of their roles, and their current authorization version
+func GetUserPermissions(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	roles, permissions, err := userAuthorization(user.ID)
+	if err != nil {
+		log.Printf("Error querying user permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"user_id":       user.ID,
+		"roles":         roles,
+		"permissions":   permissions,
+		"authz_version": user.AuthzVersion,
+	})
+}
+
+// AssignUserRole assigns a role to a user
+func AssignUserRole(c *fiber.Ctx) error {
+	return changeUserRole(c, true)
+}
+
+// UnassignUserRole removes a role from a user
+func UnassignUserRole(c *fiber.Ctx) error {
+	return changeUserRole(c, false)
+}
+
+// changeUserRole assigns or removes a role of a user. The user's tokens become
+// stale when the assignment actually changes.
+func changeUserRole(c *fiber.Ctx, assign bool) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	role, err := findRole(c.Params("role"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying role: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	var bumped []models.User
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		record := &models.UserRole{UserID: user.ID, RoleID: role.ID, CreatedAt: time.Now()}
+		action := "user.role.assign"
+
+		var res orm.Result
+		var err error
+		if assign {
+			res, err = tx.Model(record).OnConflict("DO NOTHING").Insert()
+		} else {
+			action = "user.role.unassign"
+			res, err = tx.Model(record).WherePK().Delete()
+		}
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+
+		bumped, err = bumpAuthzVersions(tx, "id = ?", user.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, action, "user", user.ID, map[string]interface{}{
+			"role": role.Name,
+		})
+	})
+	if err != nil {
+		log.Printf("Error changing user roles: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	publishAuthzVersions(c.Context(), bumped)
+
+	return ListUserRoles(c)
+}
+
+// roleDetails answers with a role and its permissions
+func roleDetails(c *fiber.Ctx, status int, role *models.Role) error {
+	permissions := []string{}
+	err := config.DB.Model((*models.Permission)(nil)).
+		ColumnExpr("permission.name").
+		Join("JOIN role_permissions AS rp ON rp.permission_id = permission.id").
+		Where("rp.role_id = ?", role.ID).
+		Order("permission.name ASC").
+		Select(&permissions)
+	if err != nil {
+		log.Printf("Error querying role permissions: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(status).JSON(roleResponse{Role: *role, Permissions: permissions})
+}
+
+// bumpAuthzVersions increments the authorization version of the matching
+// users, so the tokens issued to them before the change are detected as stale
+func bumpAuthzVersions(tx *pg.Tx, condition string, params ...interface{}) ([]models.User, error) {
+	var users []models.User
+	_, err := tx.Model(&users).
+		Set("authz_version = authz_version + 1").
+		Where(condition, params...).
+		Returning("id, authz_version").
+		Update()
+	return users, err
+}
+
+// publishAuthzVersions makes the versions bumped in a committed transaction
+// visible to TokenAuthMiddleware
+func publishAuthzVersions(ctx context.Context, users []models.User) {
+	for _, user := range users {
+		if err := auth.SetAuthzVersion(ctx, config.Redis, user.ID, user.AuthzVersion); err != nil {
+			log.Printf("Error publishing authorization version of user %s: %v", user.ID, err)
+		}
+	}
+}
+
+// findRole loads a role by ID or name
+func findRole(ref string) (*models.Role, error) {
+	var role models.Role
+	err := config.DB.Model(&role).Where("id = ? OR name = ?", ref, strings.ToLower(ref)).Limit(1).Select()
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// findPermission loads a permission by ID or name
+func findPermission(ref string) (*models.Permission, error) {
+	var permission models.Permission
+	err := config.DB.Model(&permission).Where("id = ? OR name = ?", ref, strings.ToLower(ref)).Limit(1).Select()
+	if err != nil {
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// userAuthorization loads the names of the roles of a user and of the
+// permissions those roles grant, sorted
+func userAuthorization(userID string) (roles []string, permissions []string, err error) {
+	roles = []string{}
+	err = config.DB.Model((*models.Role)(nil)).
+		ColumnExpr("DISTINCT role.name").
+		Join("JOIN user_roles AS ur ON ur.role_id = role.id").
+		Where("ur.user_id = ?", userID).
+		Order("role.name ASC").
+		Select(&roles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	permissions = []string{}
+	err = config.DB.Model((*models.Permission)(nil)).
+		ColumnExpr("DISTINCT permission.name").
+		Join("JOIN role_permissions AS rp ON rp.permission_id = permission.id").
+		Join("JOIN user_roles AS ur ON ur.role_id = rp.role_id").
+		Where("ur.user_id = ?", userID).
+		Order("permission.name ASC").
+		Select(&permissions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return roles, permissions, nil
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RefreshTokenRequest struct to capture the refresh token to rotate
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenOptions describes the session tokens are issued for
+type tokenOptions struct {
+	FamilyID string // Refresh token family to continue, empty starts a new session
+	ClientID string // OAuth client the tokens are issued to, empty for first-party logins
+	Scope    string // Space-delimited scopes granted to the session
+	OrgID    string // Organization to scope first-party tokens to, empty for the user's default organization
+	Nonce    string // OpenID Connect nonce from the authorization request
+	AuthTime int64  // Unix time the user authenticated, for the ID token
+}
+
+// errInvalidRefreshToken is returned when a refresh token cannot be rotated
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// errUserDeactivated is returned by issueTokens for deactivated users
+var errUserDeactivated = errors.New("account deactivated")
+
+// issueTokens generates an access token and a new opaque refresh token for the user
+func issueTokens(user *models.User, opts tokenOptions) (fiber.Map, error) {
+	if !user.Active() {
+		return nil, errUserDeactivated
+	}
+
+	claims := map[string]interface{}{
+		"email_verified": user.EmailVerified,
+	}
+	if opts.ClientID != "" {
+		claims["client_id"] = opts.ClientID
+	} else {
+		// Only first-party tokens carry the user's privileges, a third-party
+		// application is limited to its scopes
+		roles, permissions, err := userAuthorization(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		claims[auth.ClaimRoles] = roles
+		claims[auth.ClaimPermissions] = permissions
+		claims[auth.ClaimAuthzVersion] = user.AuthzVersion
+
+		membership, err := sessionMembership(user.ID, opts.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if membership != nil {
+			opts.OrgID = membership.OrgID
+			claims[auth.ClaimOrgID] = membership.OrgID
+			claims[auth.ClaimOrgRole] = membership.Role
+		}
+	}
+	if opts.Scope != "" {
+		claims["scope"] = opts.Scope
+	}
+
+	accessToken, err := auth.GenerateTokenWithClaims(user.ID, user.Email, auth.AccessTokenExpirationHours, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.NewRefreshToken(user.ID, opts.FamilyID, tokenHash, auth.RefreshTokenTTL)
+	record.ClientID = opts.ClientID
+	record.Scope = opts.Scope
+	record.OrgID = opts.OrgID
+	if _, err := config.DB.Model(record).Insert(); err != nil {
+		return nil, err
+	}
+
+	tokens := fiber.Map{
+		"token":         accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    auth.AccessTokenExpirationHours * 3600,
+		"refresh_token": refreshToken,
+	}
+	if opts.Scope != "" {
+		tokens["scope"] = opts.Scope
+	}
+
+	// OpenID Connect clients also get an ID token
+	if opts.ClientID != "" && auth.HasScope(opts.Scope, "openid") {
+		idToken, err := auth.GenerateIDToken(auth.IDTokenParams{
+			Subject:     user.ID,
+			Audience:    opts.ClientID,
+			Nonce:       opts.Nonce,
+			AuthTime:    opts.AuthTime,
+			AccessToken: accessToken,
+			Claims:      userClaims(user, opts.Scope),
+		})
+		if err != nil {
+			return nil, err
+		}
+		tokens["id_token"] = idToken
+	}
+
+	return tokens, nil
+}
+
+// RefreshToken rotates a refresh token: the presented token is marked as used and
+// a new access/refresh token pair in the same family is returned. Presenting a
+// token that was already used revokes the whole family.
+func RefreshToken(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	tokens, err := rotateRefreshToken(req.RefreshToken, "")
+	if err == errInvalidRefreshToken {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid refresh token",
+		})
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// rotateRefreshToken consumes a refresh token issued to clientID (empty for
+// first-party sessions) and issues the next token pair of its family
+func rotateRefreshToken(refreshToken, clientID string) (fiber.Map, error) {
+	tokenHash := auth.HashOpaqueToken(refreshToken)
+	now := time.Now()
+
+	// Atomically claim the token so concurrent requests cannot both rotate it
+	var record models.RefreshToken
+	res, err := config.DB.Model(&record).
+		Set("used_at = ?", now).
+		Where("token_hash = ?", tokenHash).
+		Where("coalesce(client_id, '') = ?", clientID).
+		Where("used_at IS NULL").
+		Where("revoked_at IS NULL").
+		Where("expires_at > ?", now).
+		Returning("*").
+		Update()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, err
+	}
+
+	if res == nil || res.RowsAffected() == 0 {
+		detectRefreshTokenReuse(tokenHash)
+		return nil, errInvalidRefreshToken
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("id = ?", record.UserID).Select()
+	if err == pg.ErrNoRows {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := issueTokens(&user, tokenOptions{
+		FamilyID: record.FamilyID,
+		ClientID: record.ClientID,
+		Scope:    record.Scope,
+		OrgID:    record.OrgID,
+	})
+	if err == errNotOrgMember || err == errUserDeactivated {
+		// The user left the organization the session was scoped to, or was deactivated
+		return nil, errInvalidRefreshToken
+	}
+	return tokens, err
+}
+
+// detectRefreshTokenReuse handles a refresh token that could not be rotated. If
+// the token exists but was already used, it has been replayed and the whole
+// family is revoked.
+func detectRefreshTokenReuse(tokenHash string) {
+	var record models.RefreshToken
+	err := config.DB.Model(&record).Where("token_hash = ?", tokenHash).Select()
+	if err != nil {
+		if err != pg.ErrNoRows {
+			log.Printf("Error querying refresh token: %v", err)
+		}
+		return
+	}
+
+	if record.UsedAt != nil && record.RevokedAt == nil {
+		log.Printf("Refresh token reuse detected for user %s, revoking family %s", record.UserID, record.FamilyID)
+		if err := revokeTokenFamily(record.FamilyID); err != nil {
+			log.Printf("Error revoking refresh token family: %v", err)
+		}
+	}
+}
+
+// revokeTokenFamily revokes every refresh token of a family
+func revokeTokenFamily(familyID string) error {
+	_, err := config.DB.Model((*models.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("family_id = ?", familyID).
+		Where("revoked_at IS NULL").
+		Update()
+	return err
+}
+
+// revokeAllSessions revokes every access and refresh token issued to the user
+func revokeAllSessions(ctx context.Context, userID string) error {
+	if err := auth.RevokeAllTokens(ctx, config.Redis, userID); err != nil {
+		return err
+	}
+	return revokeUserRefreshTokens(userID)
+}
+
+// revokeUserRefreshTokens revokes every refresh token of a user
+func revokeUserRefreshTokens(userID string) error {
+	_, err := config.DB.Model((*models.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("user_id = ?", userID).
+		Where("revoked_at IS NULL").
+		Update()
+	return err
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	tokenHashCondition = regexp.MustCompile(`token_hash = '([0-9a-f]+)'`)
+	clientIDCondition  = regexp.MustCompile(`coalesce\(client_id, ''\) = '([^']*)'`)
+	familyIDCondition  = regexp.MustCompile(`family_id = '([^']+)'`)
+)
+
+// refreshTokenStore is the refresh_tokens table of a fake database. It claims
+// tokens atomically like the conditional UPDATE does in PostgreSQL.
+type refreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshToken // By token hash
+}
+
+func newRefreshTokenStore(db *fakePostgres) *refreshTokenStore {
+	store := &refreshTokenStore{tokens: map[string]*models.RefreshToken{}}
+
+	db.Handle(`INSERT INTO "refresh_tokens"`, func(query string) fakeResult {
+		values := fakeInsertedValues(query)
+		record := models.NewRefreshToken(values["user_id"], values["family_id"], values["token_hash"], time.Hour)
+		record.ClientID = values["client_id"]
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.tokens[record.TokenHash] = record
+		return fakeTag("INSERT 0 1")
+	})
+	db.Handle(`FROM "refresh_tokens"`, func(query string) fakeResult {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		record, ok := store.tokens[tokenHashCondition.FindStringSubmatch(query)[1]]
+		if !ok {
+			return fakeRows()
+		}
+		return fakeRows(record)
+	})
+	db.Handle(`UPDATE "refresh_tokens" AS "refresh_token" SET used_at`, func(query string) fakeResult {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		record, ok := store.tokens[tokenHashCondition.FindStringSubmatch(query)[1]]
+		if !ok || record.ClientID != clientIDCondition.FindStringSubmatch(query)[1] ||
+			record.UsedAt != nil || record.RevokedAt != nil || record.ExpiresAt.Before(time.Now()) {
+			return fakeTag("UPDATE 0")
+		}
+		now := time.Now()
+		record.UsedAt = &now
+		res := fakeRows(record)
+		res.tag = "UPDATE 1"
+		return res
+	})
+	db.Handle(`UPDATE "refresh_tokens" AS "refresh_token" SET revoked_at`, func(query string) fakeResult {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		familyID := familyIDCondition.FindStringSubmatch(query)[1]
+		now := time.Now()
+		for _, record := range store.tokens {
+			if record.FamilyID == familyID && record.RevokedAt == nil {
+				record.RevokedAt = &now
+			}
+		}
+		return fakeTag("UPDATE 1")
+	})
+	return store
+}
+
+// issue stores a new first-party refresh token of userID and returns it
+func (s *refreshTokenStore) issue(t *testing.T, userID string) string {
+	t.Helper()
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[tokenHash] = models.NewRefreshToken(userID, "", tokenHash, time.Hour)
+	return token
+}
+
+// get returns the stored record of a token
+func (s *refreshTokenStore) get(token string) models.RefreshToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.tokens[auth.HashOpaqueToken(token)]
+}
+
+func newRefreshFixture(t *testing.T) (*fiber.App, *refreshTokenStore, *models.User) {
+	newTestRedis(t)
+	db := newFakePostgres(t)
+	user := newTestUser(t)
+
+	db.Handle(`FROM "users"`, func(string) fakeResult {
+		return fakeRows(user)
+	})
+	db.Handle(`FROM "roles"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	db.Handle(`FROM "permissions"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	db.Handle(`FROM "memberships"`, func(string) fakeResult {
+		return fakeRows()
+	})
+	store := newRefreshTokenStore(db)
+
+	app := fiber.New()
+	app.Post("/refresh", RefreshToken)
+	return app, store, user
+}
+
+// refresh redeems a refresh token and returns the status and the next refresh token
+func refresh(t *testing.T, app *fiber.App, token string) (int, string) {
+	t.Helper()
+
+	status, body := doJSON(t, app, http.MethodPost, "/refresh", RefreshTokenRequest{RefreshToken: token})
+	next, _ := body["refresh_token"].(string)
+	return status, next
+}
+
+func TestRefreshTokenSingleUse(t *testing.T) {
+	app, store, user := newRefreshFixture(t)
+	first := store.issue(t, user.ID)
+
+	status, second := refresh(t, app, first)
+	if status != http.StatusOK || second == "" || second == first {
+		t.Fatalf("refresh = %d %q, want 200 and a new refresh token", status, second)
+	}
+	if store.get(first).UsedAt == nil {
+		t.Error("the redeemed token is not marked used")
+	}
+	if got, want := store.get(second).FamilyID, store.get(first).FamilyID; got != want {
+		t.Errorf("family of the rotated token = %s, want %s", got, want)
+	}
+
+	status, third := refresh(t, app, second)
+	if status != http.StatusOK || third == "" {
+		t.Fatalf("refresh with the rotated token = %d %q, want 200", status, third)
+	}
+}
+
+func TestRefreshTokenReplayRevokesFamily(t *testing.T) {
+	app, store, user := newRefreshFixture(t)
+	first := store.issue(t, user.ID)
+
+	status, second := refresh(t, app, first)
+	if status != http.StatusOK {
+		t.Fatalf("refresh = %d, want 200", status)
+	}
+
+	// Replaying the used token revokes every token of the family, including
+	// the one the legitimate client holds now
+	if status, _ := refresh(t, app, first); status != http.StatusUnauthorized {
+		t.Errorf("replay = %d, want 401", status)
+	}
+	if store.get(second).RevokedAt == nil {
+		t.Error("the rotated token was not revoked after the replay")
+	}
+	if status, _ := refresh(t, app, second); status != http.StatusUnauthorized {
+		t.Errorf("refresh with the revoked token = %d, want 401", status)
+	}
+}
+
+func TestRefreshTokenConcurrentRedemption(t *testing.T) {
+	app, store, user := newRefreshFixture(t)
+	token := store.issue(t, user.ID)
+
+	const requests = 8
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _ := refresh(t, app, token)
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	succeeded := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("status = %d, want 200 or 401", status)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent redemptions succeeded, want exactly 1", succeeded, requests)
+	}
+}
@@ -1,38 +1,668 @@
 package controllers
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/drive-deep/auth-microservices/auth"
 	"github.com/drive-deep/auth-microservices/config" // Update this path to your actual project path
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
 	"github.com/drive-deep/auth-microservices/models" // Update this path to your actual project path
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// userPageSize and userMaxPageSize bound the users returned by GetUserDetails
+const (
+	userPageSize    = 50
+	userMaxPageSize = 200
+)
+
+// userSortColumns maps the sorts of the user listing to their SQL expressions,
+// each backed by an index together with the ID
+var userSortColumns = map[string]string{
+	"created_at": "?TableAlias.created_at",
+	"email":      "?TableAlias.email",
+	"last_name":  "coalesce(?TableAlias.last_name, '')",
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// userListItem is a user as listed by GetUserDetails
+type userListItem struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	EmailVerified bool      `json:"email_verified"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// userCursor is the position after which the next page of users starts
+type userCursor struct {
+	Sort  string `json:"s"`  // Sort of the listing the cursor belongs to
+	Value string `json:"v"`  // Sort key of the last user of the page
+	ID    string `json:"id"` // ID of the last user of the page, to break ties
+}
+
+// UpdateProfileRequest struct to capture the profile fields users can change themselves
+type UpdateProfileRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+}
+
+// UpdateUserRequest struct to capture the fields an administrator can change
+type UpdateUserRequest struct {
+	FirstName     *string `json:"first_name"`
+	LastName      *string `json:"last_name"`
+	EmailVerified *bool   `json:"email_verified"`
+	Status        *string `json:"status"`
+}
+
 // GetUserDetails handles GET requests to fetch all user details
-// GetUserDetails handles GET requests to fetch specific user details
+// GetUserDetails handles GET requests to fetch specific user details, a page at
+// a time. Filters: status, role (name), org (ID), created_after and
+// created_before (RFC 3339), and q, a case-insensitive prefix of the email,
+// first or last name. sort is created_at, email or last_name, prefixed with
+// "-" for descending order (default -created_at). Pages are fetched with limit
+// and the next_cursor of the previous page.
 func GetUserDetails(c *fiber.Ctx) error {
-	// Define a slice to hold the list of users with only specific fields
-	var users []struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Email     string `json:"email"`
+	limit := userPageSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > userMaxPageSize {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "limit must be between 1 and " + strconv.Itoa(userMaxPageSize),
+			})
+		}
+		limit = n
 	}
 
-	// Fetch specific fields (first_name, last_name, email) from the database
-	err := config.DB.Model(&models.User{}).
-		Column("first_name", "last_name", "email"). // go-pg's Column method to select specific fields
-		Select(&users)                              // Use Select to fill the slice with the data
+	sort := c.Query("sort", "-created_at")
+	sortExpr, ok := userSortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "sort must be one of created_at, email, last_name, optionally prefixed with -",
+		})
+	}
+	direction, comparison := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, comparison = "DESC", "<"
+	}
+
+	// Fetch specific fields from the database, limited to the caller's organization
+	q := config.DB.Model((*models.User)(nil)).
+		Column("id", "email", "first_name", "last_name", "email_verified", "status", "created_at") // go-pg's Column method to select specific fields
+	q, err := filterUsers(c, scopeUsersToOrg(c, q))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	total, err := q.Clone().Count()
+	if err != nil {
+		log.Printf("Error counting users: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch users",
+		})
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeUserCursor(value, sort)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cursor",
+			})
+		}
+		q = q.Where("("+sortExpr+", ?TableAlias.id) "+comparison+" (?, ?)", cursor.value(), cursor.ID)
+	}
+
+	// One more row than requested tells whether there is a next page
+	users := []userListItem{}
+	err = q.OrderExpr(sortExpr + " " + direction).
+		OrderExpr("?TableAlias.id " + direction).
+		Limit(limit + 1).
+		Select(&users) // Use Select to fill the slice with the data
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch users",
 		})
 	}
-	if len(users) == 0 {
-		return c.Status(http.StatusOK).JSON([]interface{}{})
+
+	var nextCursor interface{}
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor = encodeUserCursor(sort, &users[limit-1])
+	}
+
+	// Return the page of users in JSON format
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"users":       users,
+		"total":       total,
+		"next_cursor": nextCursor,
+	})
+}
+
+// filterUsers applies the filters of the user listing's query string
+func filterUsers(c *fiber.Ctx, q *orm.Query) (*orm.Query, error) {
+	if status := c.Query("status"); status != "" {
+		if status != models.UserStatusActive && status != models.UserStatusDeactivated {
+			return nil, errors.New("status must be one of active, deactivated")
+		}
+		q = q.Where("?TableAlias.status = ?", status)
+	}
+	if role := c.Query("role"); role != "" {
+		q = q.Where("?TableAlias.id IN (SELECT ur.user_id FROM user_roles AS ur JOIN roles AS r ON r.id = ur.role_id WHERE r.name = ?)", role)
+	}
+	if orgID := c.Query("org"); orgID != "" {
+		q = q.Where("?TableAlias.id IN (SELECT user_id FROM memberships WHERE org_id = ?)", orgID)
+	}
+
+	for param, comparison := range map[string]string{"created_after": ">=", "created_before": "<"} {
+		if value := c.Query(param); value != "" {
+			date, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, errors.New(param + " must be an RFC 3339 date")
+			}
+			q = q.Where("?TableAlias.created_at "+comparison+" ?", date)
+		}
+	}
+
+	// Prefix search, served by the lower(...) text_pattern_ops indexes
+	if search := strings.TrimSpace(c.Query("q")); search != "" {
+		pattern := likeEscaper.Replace(strings.ToLower(search)) + "%"
+		q = q.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.WhereOr("lower(?TableAlias.email) LIKE ?", pattern).
+				WhereOr("lower(?TableAlias.first_name) LIKE ?", pattern).
+				WhereOr("lower(?TableAlias.last_name) LIKE ?", pattern), nil
+		})
+	}
+
+	return q, nil
+}
+
+// encodeUserCursor returns the opaque cursor of the page following the user
+func encodeUserCursor(sort string, user *userListItem) string {
+	cursor := userCursor{Sort: sort, ID: user.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "email":
+		cursor.Value = user.Email
+	case "last_name":
+		cursor.Value = user.LastName
+	default:
+		cursor.Value = user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeUserCursor reads a cursor, which must come from a listing with the same sort
+func decodeUserCursor(value, sort string) (*userCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort || cursor.ID == "" {
+		return nil, errors.New("cursor does not match the sort")
+	}
+	if _, ok := cursor.value().(time.Time); !ok && strings.TrimPrefix(sort, "-") == "created_at" {
+		return nil, errors.New("invalid cursor date")
+	}
+	return &cursor, nil
+}
+
+// value returns the sort key of the cursor as a query parameter
+func (cursor *userCursor) value() interface{} {
+	if strings.TrimPrefix(cursor.Sort, "-") == "created_at" {
+		date, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil
+		}
+		return date
+	}
+	return cursor.Value
+}
+
+// findUserByID loads a user by primary key
+func findUserByID(userID string) (*models.User, error) {
+	var user models.User
+	if err := config.DB.Model(&user).Where("id = ?", userID).Select(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetMe returns the logged-in user's profile
+func GetMe(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	user, err := findUserByID(userID)
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(user)
+}
+
+// UpdateMe changes the logged-in user's first and last name
+func UpdateMe(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil || !validName(req.FirstName) || !validName(req.LastName) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	user, err := findUserByID(userID)
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	applyName(&user.FirstName, req.FirstName)
+	applyName(&user.LastName, req.LastName)
+	user.UpdatedAt = time.Now()
+
+	if _, err := config.DB.Model(user).Column("first_name", "last_name", "updated_at").WherePK().Update(); err != nil {
+		log.Printf("Error updating user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(user)
+}
+
+// GetUser returns a user's profile (users:read)
+func GetUser(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(user)
+}
+
+// UpdateUser changes a user's name, email verification or status (users:write).
+// Deactivating a user revokes all their sessions and refuses their tokens;
+// callers cannot deactivate themselves. The account is shared by all the
+// user's organizations, so only the admin API token changes it: callers scoped
+// to an organization can only suspend or reactivate the membership in it.
+func UpdateUser(c *fiber.Ctx) error {
+	var req UpdateUserRequest
+	if err := c.BodyParser(&req); err != nil || !validName(req.FirstName) || !validName(req.LastName) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+	if req.Status != nil && *req.Status != models.UserStatusActive && *req.Status != models.UserStatusDeactivated {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "status must be one of active, deactivated",
+		})
+	}
+
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if !middlewares.IsAdmin(c) {
+		if req.FirstName != nil || req.LastName != nil || req.EmailVerified != nil {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error": "Only platform administrators can change a user's profile",
+			})
+		}
+		return updateMemberStatus(c, user, req.Status)
+	}
+
+	wasActive := user.Active()
+	deactivate := req.Status != nil && *req.Status == models.UserStatusDeactivated && wasActive
+	reactivate := req.Status != nil && *req.Status == models.UserStatusActive && !wasActive
+	if deactivate && user.ID == auditActor(c) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot deactivate your own account",
+		})
+	}
+
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+	now := time.Now()
+	if req.FirstName != nil && strings.TrimSpace(*req.FirstName) != user.FirstName {
+		before["first_name"], after["first_name"] = user.FirstName, strings.TrimSpace(*req.FirstName)
+		applyName(&user.FirstName, req.FirstName)
+	}
+	if req.LastName != nil && strings.TrimSpace(*req.LastName) != user.LastName {
+		before["last_name"], after["last_name"] = user.LastName, strings.TrimSpace(*req.LastName)
+		applyName(&user.LastName, req.LastName)
+	}
+	if req.EmailVerified != nil && *req.EmailVerified != user.EmailVerified {
+		before["email_verified"], after["email_verified"] = user.EmailVerified, *req.EmailVerified
+		user.EmailVerified = *req.EmailVerified
+		user.EmailVerifiedAt = nil
+		if user.EmailVerified {
+			user.EmailVerifiedAt = &now
+		}
+	}
+	if deactivate {
+		user.Status = models.UserStatusDeactivated
+		user.DeactivatedAt = &now
+	}
+	if reactivate {
+		user.Status = models.UserStatusActive
+		user.DeactivatedAt = nil
+	}
+	if len(after) == 0 && !deactivate && !reactivate {
+		return c.Status(http.StatusOK).JSON(user)
+	}
+	user.UpdatedAt = now
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		_, err := tx.Model(user).
+			Column("first_name", "last_name", "email_verified", "email_verified_at", "status", "deactivated_at", "updated_at").
+			WherePK().
+			Update()
+		if err != nil {
+			return err
+		}
+
+		if len(after) > 0 {
+			err := recordAudit(tx, c, "user.update", "user", user.ID, map[string]interface{}{
+				"before": before,
+				"after":  after,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if deactivate {
+			return recordAudit(tx, c, "user.deactivate", "user", user.ID, nil)
+		}
+		if reactivate {
+			return recordAudit(tx, c, "user.reactivate", "user", user.ID, nil)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if deactivate {
+		if err := deactivateSessions(c, user.ID); err != nil {
+			log.Printf("Error revoking sessions of deactivated user: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+	}
+	if reactivate {
+		if err := auth.ClearUserDeactivated(c.Context(), config.Redis, user.ID); err != nil {
+			log.Printf("Error reactivating user: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(user)
+}
+
+// DeleteUser deletes a user together with their sessions, second factors,
+// API keys, roles and memberships (users:write). Callers cannot delete
+// themselves, and the last owner of an organization cannot be deleted. Only
+// the admin API token deletes accounts: callers scoped to an organization
+// remove the user from it instead.
+func DeleteUser(c *fiber.Ctx) error {
+	user, err := findUserInScope(c, c.Params("id"))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if user.ID == auditActor(c) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot delete your own account",
+		})
+	}
+
+	if !middlewares.IsAdmin(c) {
+		return removeMember(c, user)
+	}
+
+	err = config.DB.RunInTransaction(c.Context(), func(tx *pg.Tx) error {
+		var owned []models.Membership
+		err := tx.Model(&owned).Where("user_id = ?", user.ID).Where("role = ?", models.OrgRoleOwner).Select()
+		if err != nil {
+			return err
+		}
+		for _, membership := range owned {
+			if err := ensureAnotherOwner(tx, membership.OrgID, user.ID); err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{
+			(*models.Membership)(nil),
+			(*models.UserRole)(nil),
+			(*models.RefreshToken)(nil),
+			(*models.UserMFA)(nil),
+			(*models.WebAuthnCredential)(nil),
+			(*models.APIKey)(nil),
+		} {
+			if _, err := tx.Model(model).Where("user_id = ?", user.ID).Delete(); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Model(user).WherePK().Delete(); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, "user.delete", "user", user.ID, map[string]interface{}{
+			"email": user.Email,
+		})
+	})
+	if err == errLastOwner {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The user is the last owner of an organization",
+		})
+	}
+	if err != nil {
+		log.Printf("Error deleting user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Access tokens still in circulation are refused until they expire
+	if err := deactivateSessions(c, user.ID); err != nil {
+		log.Printf("Error revoking sessions of deleted user: %v", err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "User deleted",
+	})
+}
+
+// updateMemberStatus suspends or reactivates the user's membership in the
+// caller's organization, for UpdateUser callers scoped to an organization
+func updateMemberStatus(c *fiber.Ctx, user *models.User, status *string) error {
+	membership, err := memberToManage(c, user)
+	if err != nil {
+		return err
+	}
+	if membership == nil {
+		return nil
+	}
+
+	if status == nil {
+		return c.Status(http.StatusOK).JSON(membership)
+	}
+	memberStatus := models.MembershipStatusActive
+	if *status == models.UserStatusDeactivated {
+		memberStatus = models.MembershipStatusSuspended
+	}
+	if memberStatus == models.MembershipStatusSuspended && user.ID == auditActor(c) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot suspend your own membership",
+		})
+	}
+
+	err = setMembershipStatus(c, membership, memberStatus)
+	if err == errLastOwner {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The organization must keep an owner",
+		})
+	}
+	if err != nil {
+		log.Printf("Error updating membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(membership)
+}
+
+// removeMember removes the user from the caller's organization, for
+// DeleteUser callers scoped to an organization
+func removeMember(c *fiber.Ctx, user *models.User) error {
+	membership, err := memberToManage(c, user)
+	if err != nil {
+		return err
+	}
+	if membership == nil {
+		return nil
+	}
+
+	err = removeMembership(c, membership)
+	if err == errLastOwner {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "The organization must keep an owner",
+		})
+	}
+	if err != nil {
+		log.Printf("Error removing membership: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Member removed",
+	})
+}
+
+// memberToManage loads the user's membership in the caller's organization.
+// Only owners manage owners. When the membership cannot be managed the
+// response is written and nil is returned.
+func memberToManage(c *fiber.Ctx, user *models.User) (*models.Membership, error) {
+	orgID, _ := c.Locals("org_id").(string)
+	orgRole, _ := c.Locals("org_role").(string)
+
+	membership, err := findMembership(orgID, user.ID)
+	if err == pg.ErrNoRows {
+		return nil, c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying membership: %v", err)
+		return nil, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if membership.Role == models.OrgRoleOwner && orgRole != models.OrgRoleOwner {
+		return nil, c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Only owners can manage owners",
+		})
+	}
+	return membership, nil
+}
+
+// deactivateSessions revokes every session of a deactivated or deleted user
+// and refuses their access tokens
+func deactivateSessions(c *fiber.Ctx, userID string) error {
+	if err := revokeAllSessions(c.Context(), userID); err != nil {
+		return err
+	}
+	return auth.MarkUserDeactivated(c.Context(), config.Redis, userID)
+}
+
+// accountDeactivatedError answers a login attempt of a deactivated user
+func accountDeactivatedError(c *fiber.Ctx) error {
+	return c.Status(http.StatusForbidden).JSON(fiber.Map{
+		"error": "Account deactivated",
+	})
+}
+
+// validName reports whether an optional name field is either absent or not blank
+func validName(name *string) bool {
+	return name == nil || strings.TrimSpace(*name) != ""
+}
+
+// applyName sets an optional name field when it is present
+func applyName(field *string, name *string) {
+	if name != nil {
+		*field = strings.TrimSpace(*name)
 	}
-	// Return the list of users in JSON format
-	return c.Status(http.StatusOK).JSON(users)
 }
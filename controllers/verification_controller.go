@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Email verification policies (EMAIL_VERIFICATION_POLICY)
+const (
+	VerificationPolicyOff      = "off"      // Unverified users can log in normally
+	VerificationPolicyRestrict = "restrict" // Unverified users get tokens, routes requiring a verified email refuse them
+	VerificationPolicyBlock    = "block"    // Unverified users cannot log in
+)
+
+// emailVerificationPolicy decides what unverified users may do
+var emailVerificationPolicy = strings.ToLower(config.GetEnv("EMAIL_VERIFICATION_POLICY", VerificationPolicyBlock))
+
+// emailVerificationTTL is how long a verification link stays valid
+var emailVerificationTTL = time.Duration(config.GetEnvInt("EMAIL_VERIFICATION_TTL_HOURS", 24)) * time.Hour
+
+// verificationResendLimit is how many verification emails can be requested per address per hour
+var verificationResendLimit = int64(config.GetEnvInt("EMAIL_VERIFICATION_RESEND_LIMIT", 3))
+
+// errEmailNotVerified is returned by checkEmailVerified when the policy blocks unverified users
+var errEmailNotVerified = errors.New("email not verified")
+
+// pendingVerification is what a verification token stands for until it is used
+type pendingVerification struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"` // Address the link was sent to
+}
+
+// VerifyEmailRequest struct to capture the verification token
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ResendVerificationRequest struct to capture the address to send a new link to
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyEmail marks the user's email as verified. The token comes from the
+// link in the verification email (query string) or the request body.
+func VerifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		var req VerifyEmailRequest
+		if err := c.BodyParser(&req); err == nil {
+			token = req.Token
+		}
+	}
+
+	if token == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing verification token",
+		})
+	}
+
+	claims, err := auth.ValidatePurposeToken(token, auth.PurposeVerifyEmail)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired verification token",
+		})
+	}
+
+	// Consume the token so the link only works once
+	jti, _ := claims["jti"].(string)
+	var pending pendingVerification
+	err = consumeJSON(c.Context(), emailVerificationKey(jti), &pending)
+	if err != nil || pending.UserID != claims["sub"] {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired verification token",
+		})
+	}
+
+	// The token is bound to the address it was sent to
+	now := time.Now()
+	res, err := config.DB.Model((*models.User)(nil)).
+		Set("email_verified = TRUE").
+		Set("email_verified_at = ?", now).
+		Set("updated_at = ?", now).
+		Where("id = ?", pending.UserID).
+		Where("email = ?", pending.Email).
+		Update()
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if res.RowsAffected() == 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired verification token",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification sends a new verification link. It always answers the same
+// way whether or not the address is registered, to avoid account enumeration.
+func ResendVerification(c *fiber.Ctx) error {
+	var req ResendVerificationRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	count, err := config.Redis.Incr(c.Context(), "verify_email:throttle:"+strings.ToLower(req.Email), time.Hour)
+	if err != nil {
+		log.Printf("Error throttling verification email: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if count > verificationResendLimit {
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many verification emails requested. Try again later.",
+		})
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("email = ?", req.Email).Select()
+	if err != nil && err != pg.ErrNoRows {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if err == nil && !user.EmailVerified {
+		if err := sendVerificationEmail(c.Context(), &user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "If the address is registered and not yet verified, a verification email has been sent",
+	})
+}
+
+// sendVerificationEmail issues a single-use verification token for the user's
+// current email address and sends the verification link in the language
+// preferred by lang
+func sendVerificationEmail(ctx context.Context, user *models.User, lang string) error {
+	token, jti, err := auth.GeneratePurposeToken(auth.PurposeVerifyEmail, user.ID, nil, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	pending := pendingVerification{UserID: user.ID, Email: user.Email}
+	if err := storeJSON(ctx, emailVerificationKey(jti), pending, emailVerificationTTL); err != nil {
+		return err
+	}
+
+	return sendMail(ctx, lang, user.Email, "verify_email", map[string]interface{}{
+		"Name":           user.FirstName,
+		"Email":          user.Email,
+		"Link":           auth.Issuer + "/verify-email?token=" + url.QueryEscape(token),
+		"ExpiresInHours": int(emailVerificationTTL.Hours()),
+	})
+}
+
+// checkEmailVerified refuses to log in unverified users when the policy is block
+func checkEmailVerified(user *models.User) error {
+	if emailVerificationPolicy == VerificationPolicyBlock && !user.EmailVerified {
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// emailVerificationKey is the Redis key recording an unused verification token
+func emailVerificationKey(jti string) string {
+	return "verify_email:" + jti
+}
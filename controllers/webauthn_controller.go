@@ -0,0 +1,540 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/drive-deep/auth-microservices/webauthn"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// relyingParty is this service as seen by authenticators. The RP ID defaults to
+// the host of the issuer and the allowed origins to the issuer itself.
+var relyingParty = &webauthn.RelyingParty{
+	ID:      config.GetEnv("WEBAUTHN_RP_ID", issuerHost()),
+	Name:    config.GetEnv("WEBAUTHN_RP_NAME", mfaIssuer),
+	Origins: strings.Split(config.GetEnv("WEBAUTHN_ORIGINS", auth.Issuer), ","),
+}
+
+// webauthnSession is a pending ceremony, the challenge can only be answered once
+type webauthnSession struct {
+	Challenge []byte `json:"challenge"`
+	UserID    string `json:"user_id,omitempty"`
+}
+
+// FinishWebAuthnRegistrationRequest struct to capture a new credential
+type FinishWebAuthnRegistrationRequest struct {
+	Name       string                         `json:"name"`
+	Credential *webauthn.RegistrationResponse `json:"credential"`
+}
+
+// registeredCredential is the answer to a registration, with the recovery codes
+// of users who had none yet
+type registeredCredential struct {
+	models.WebAuthnCredential
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// BeginWebAuthnLoginRequest struct to capture the optional email of a passkey login
+type BeginWebAuthnLoginRequest struct {
+	Email string `json:"email"`
+}
+
+// FinishWebAuthnLoginRequest struct to capture the assertion of a passkey login
+type FinishWebAuthnLoginRequest struct {
+	SessionID  string                      `json:"session_id"`
+	Credential *webauthn.AssertionResponse `json:"credential"`
+}
+
+// BeginWebAuthnMFARequest struct to capture the challenge of a login waiting for its second factor
+type BeginWebAuthnMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// BeginWebAuthnRegistration returns the options for navigator.credentials.create
+// to register a new passkey or security key for the logged-in user
+func BeginWebAuthnRegistration(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	user, err := findUserByID(userID)
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	credentials, err := findWebAuthnCredentials(userID)
+	if err != nil {
+		log.Printf("Error querying WebAuthn credentials: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// The same authenticator cannot be registered twice
+	options, err := relyingParty.NewCreationOptions(webauthn.User{
+		ID:          []byte(user.ID),
+		Name:        user.Email,
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+	}, credentialDescriptors(credentials))
+	if err != nil {
+		log.Printf("Error generating WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	session := webauthnSession{Challenge: options.Challenge, UserID: userID}
+	if err := storeJSON(c.Context(), webauthnRegistrationKey(userID), session, webauthn.Timeout); err != nil {
+		log.Printf("Error storing WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"publicKey": options,
+	})
+}
+
+// FinishWebAuthnRegistration verifies the response of navigator.credentials.create
+// and stores the new credential. Users without recovery codes get them in the
+// response, shown only this once.
+func FinishWebAuthnRegistration(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var req FinishWebAuthnRegistrationRequest
+	if err := c.BodyParser(&req); err != nil || req.Credential == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	var session webauthnSession
+	if err := consumeJSON(c.Context(), webauthnRegistrationKey(userID), &session); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Registration expired, please start again",
+		})
+	}
+
+	credential, err := relyingParty.VerifyRegistration(session.Challenge, req.Credential, false)
+	if err != nil {
+		log.Printf("Error verifying WebAuthn registration: %v", err)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid credential",
+		})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	aaguid, _ := uuid.FromBytes(credential.AAGUID)
+
+	record := models.WebAuthnCredential{
+		ID:             base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserID:         userID,
+		Name:           name,
+		PublicKey:      credential.PublicKey,
+		SignCount:      int64(credential.SignCount),
+		AAGUID:         aaguid.String(),
+		Transports:     credential.Transports,
+		BackupEligible: credential.BackupEligible,
+		CreatedAt:      time.Now(),
+	}
+	res, err := config.DB.Model(&record).OnConflict("(id) DO NOTHING").Insert()
+	if err != nil {
+		log.Printf("Error storing WebAuthn credential: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if res.RowsAffected() == 0 {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "Credential already registered",
+		})
+	}
+
+	// A passkey makes the second factor mandatory, so back it up with recovery
+	// codes unless the user already has some
+	codes, err := ensureRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusCreated).JSON(registeredCredential{
+		WebAuthnCredential: record,
+		RecoveryCodes:      codes,
+	})
+}
+
+// ListWebAuthnCredentials lists the passkeys and security keys of the logged-in user
+func ListWebAuthnCredentials(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	credentials, err := findWebAuthnCredentials(userID)
+	if err != nil {
+		log.Printf("Error querying WebAuthn credentials: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"credentials": credentials,
+	})
+}
+
+// DeleteWebAuthnCredential removes a credential of the logged-in user
+func DeleteWebAuthnCredential(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	res, err := config.DB.Model((*models.WebAuthnCredential)(nil)).
+		Where("id = ?", c.Params("id")).
+		Where("user_id = ?", userID).
+		Delete()
+	if err != nil {
+		log.Printf("Error deleting WebAuthn credential: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if res.RowsAffected() == 0 {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Credential not found",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message": "Credential deleted",
+	})
+}
+
+// BeginWebAuthnLogin starts a passwordless login. With an email, only that
+// user's credentials are allowed; without one the browser offers every passkey
+// it has for the site. Unknown emails get the same response as known ones.
+func BeginWebAuthnLogin(c *fiber.Ctx) error {
+	var req BeginWebAuthnLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	var allow []webauthn.CredentialDescriptor
+	if req.Email != "" {
+		var user models.User
+		err := config.DB.Model(&user).Where("email = ?", req.Email).Select()
+		if err != nil && err != pg.ErrNoRows {
+			log.Printf("Error querying user: %v", err)
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		if err == nil {
+			credentials, err := findWebAuthnCredentials(user.ID)
+			if err != nil {
+				log.Printf("Error querying WebAuthn credentials: %v", err)
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Internal server error",
+				})
+			}
+			allow = credentialDescriptors(credentials)
+		}
+	}
+
+	// A passkey replaces both the password and the second factor, so the
+	// authenticator must verify the user (PIN, biometrics)
+	options, err := relyingParty.NewRequestOptions(allow, webauthn.UserVerificationRequired)
+	if err != nil {
+		log.Printf("Error generating WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	sessionID := uuid.New().String()
+	if err := storeJSON(c.Context(), webauthnLoginKey(sessionID), webauthnSession{Challenge: options.Challenge}, webauthn.Timeout); err != nil {
+		log.Printf("Error storing WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"session_id": sessionID,
+		"publicKey":  options,
+	})
+}
+
+// FinishWebAuthnLogin verifies the response of navigator.credentials.get and
+// issues the same tokens as Login
+func FinishWebAuthnLogin(c *fiber.Ctx) error {
+	var req FinishWebAuthnLoginRequest
+	if err := c.BodyParser(&req); err != nil || req.SessionID == "" || req.Credential == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	var session webauthnSession
+	if err := consumeJSON(c.Context(), webauthnLoginKey(req.SessionID), &session); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Login expired, please start again",
+		})
+	}
+
+	credential, err := findWebAuthnCredential(base64.RawURLEncoding.EncodeToString(req.Credential.RawID))
+	if err == pg.ErrNoRows {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown credential",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying WebAuthn credential: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	// Locked accounts stay locked whatever the login method
+	if err := auth.DefaultLockoutPolicy.Check(c.Context(), config.Redis, credential.UserID, c.IP()); err != nil {
+		var lockoutErr *auth.LockoutError
+		if errors.As(err, &lockoutErr) {
+			return lockoutError(c, lockoutErr)
+		}
+		log.Printf("Error checking lockout: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	ok, err := verifyWebAuthnAssertion(session.Challenge, req.Credential, credential, true)
+	if err != nil {
+		log.Printf("Error verifying WebAuthn assertion: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid credential",
+		})
+	}
+
+	user, err := findUserByID(credential.UserID)
+	if err == nil {
+		err = checkEmailVerified(user)
+	}
+	if err == errEmailNotVerified {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "Email not verified",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	tokens, err := issueTokens(user, tokenOptions{})
+	if err == errUserDeactivated {
+		return accountDeactivatedError(c)
+	}
+	if err != nil {
+		log.Printf("Error generating tokens: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Error generating token",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// BeginWebAuthnMFA returns the options for navigator.credentials.get to answer
+// a login that returned mfa_required with a registered passkey or security key.
+// The assertion is then sent to /login/mfa as "webauthn".
+func BeginWebAuthnMFA(c *fiber.Ctx) error {
+	var req BeginWebAuthnMFARequest
+	if err := c.BodyParser(&req); err != nil || req.MFAToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input data",
+		})
+	}
+
+	claims, err := auth.ValidatePurposeToken(req.MFAToken, auth.PurposeMFAChallenge)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token, please log in again",
+		})
+	}
+	jti, _ := claims["jti"].(string)
+
+	var challenge mfaChallenge
+	if err := loadJSON(c.Context(), mfaChallengeKey(jti), &challenge); err != nil || challenge.UserID != claims["sub"] {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA token, please log in again",
+		})
+	}
+
+	credentials, err := findWebAuthnCredentials(challenge.UserID)
+	if err != nil {
+		log.Printf("Error querying WebAuthn credentials: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+	if len(credentials) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "No passkey or security key registered",
+		})
+	}
+
+	options, err := relyingParty.NewRequestOptions(credentialDescriptors(credentials), webauthn.UserVerificationPreferred)
+	if err != nil {
+		log.Printf("Error generating WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	session := webauthnSession{Challenge: options.Challenge, UserID: challenge.UserID}
+	if err := storeJSON(c.Context(), webauthnMFAKey(jti), session, mfaChallengeTTL); err != nil {
+		log.Printf("Error storing WebAuthn challenge: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"publicKey": options,
+	})
+}
+
+// verifyWebAuthnSecondFactor checks an assertion answering the WebAuthn
+// challenge of a pending MFA login
+func verifyWebAuthnSecondFactor(ctx context.Context, jti, userID string, resp *webauthn.AssertionResponse) (bool, error) {
+	var session webauthnSession
+	if err := consumeJSON(ctx, webauthnMFAKey(jti), &session); err != nil || session.UserID != userID {
+		return false, nil
+	}
+
+	credential, err := findWebAuthnCredential(base64.RawURLEncoding.EncodeToString(resp.RawID))
+	if err == pg.ErrNoRows || (err == nil && credential.UserID != userID) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return verifyWebAuthnAssertion(session.Challenge, resp, credential, false)
+}
+
+// verifyWebAuthnAssertion verifies an assertion made with a stored credential
+// and records the new signature counter. A counter that did not increase is
+// refused, as the credential may have been cloned.
+func verifyWebAuthnAssertion(challenge []byte, resp *webauthn.AssertionResponse, credential *models.WebAuthnCredential, requireUV bool) (bool, error) {
+	assertion, err := relyingParty.VerifyAssertion(challenge, resp, credential.PublicKey, uint32(credential.SignCount), requireUV)
+	if err == webauthn.ErrSignCount {
+		log.Printf("WebAuthn credential %s of user %s reported a signature counter that did not increase", credential.ID, credential.UserID)
+		return false, nil
+	}
+	if errors.Is(err, webauthn.ErrVerification) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != credential.UserID {
+		return false, nil
+	}
+
+	// Only move the counter forward, so concurrent assertions replaying the
+	// same counter value cannot both succeed
+	q := config.DB.Model((*models.WebAuthnCredential)(nil)).
+		Set("sign_count = ?", assertion.SignCount).
+		Set("last_used_at = ?", time.Now()).
+		Where("id = ?", credential.ID)
+	if assertion.SignCount != 0 {
+		q = q.Where("sign_count < ?", assertion.SignCount)
+	}
+	res, err := q.Update()
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+// findWebAuthnCredential loads a credential by its base64url encoded ID
+func findWebAuthnCredential(id string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	if err := config.DB.Model(&credential).Where("id = ?", id).Select(); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// findWebAuthnCredentials lists the credentials of a user, oldest first
+func findWebAuthnCredentials(userID string) ([]models.WebAuthnCredential, error) {
+	credentials := []models.WebAuthnCredential{}
+	err := config.DB.Model(&credentials).Where("user_id = ?", userID).Order("created_at ASC").Select()
+	return credentials, err
+}
+
+// credentialDescriptors lists credentials the way the WebAuthn options reference them
+func credentialDescriptors(credentials []models.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.ID)
+		if err != nil {
+			continue
+		}
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         id,
+			Transports: credential.Transports,
+		})
+	}
+	return descriptors
+}
+
+// issuerHost is the host name of the issuer URL, the default WebAuthn RP ID
+func issuerHost() string {
+	u, err := url.Parse(auth.Issuer)
+	if err != nil {
+		return "localhost"
+	}
+	return u.Hostname()
+}
+
+// webauthnRegistrationKey is the Redis key of a user's pending registration
+func webauthnRegistrationKey(userID string) string {
+	return "webauthn:register:" + userID
+}
+
+// webauthnLoginKey is the Redis key of a pending passwordless login
+func webauthnLoginKey(sessionID string) string {
+	return "webauthn:login:" + sessionID
+}
+
+// webauthnMFAKey is the Redis key of the WebAuthn challenge of a pending MFA login
+func webauthnMFAKey(jti string) string {
+	return "webauthn:mfa:" + jti
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+// JWKS publishes the public keys tokens can be verified with, so other services
+// can validate access tokens without holding any signing secret
+func JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.Status(http.StatusOK).JSON(auth.PublicJWKS())
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document
+func OpenIDConfiguration(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"issuer":                                auth.Issuer,
+		"authorization_endpoint":                auth.Issuer + "/oauth/authorize",
+		"token_endpoint":                        auth.Issuer + "/oauth/token",
+		"introspection_endpoint":                auth.Issuer + "/oauth/introspect",
+		"userinfo_endpoint":                     auth.Issuer + "/userinfo",
+		"jwks_uri":                              auth.Issuer + "/.well-known/jwks.json",
+		"scopes_supported":                      supportedScopes,
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{auth.DefaultKeyRing.Active().Algorithm},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{auth.PKCEMethodS256},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "given_name", "family_name", "updated_at", "email", "email_verified",
+		},
+	})
+}
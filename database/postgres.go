@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"reflect"
 
 	"github.com/go-pg/pg/v10"
 	// Import the db package to implement the interface
@@ -92,11 +93,16 @@ func (p *PostgresDatabase) Get(ctx context.Context, key string, result interface
 	return nil
 }
 
-// GetAll retrieves all records of the given model with optional pagination
+// GetAll retrieves the records of the given model with optional offset
+// pagination, in primary key order. model is a pointer to a struct, or to a
+// slice of structs which is filled as well.
 func (p *PostgresDatabase) GetAll(ctx context.Context, model interface{}, limit, offset int) ([]interface{}, error) {
-	var results []interface{}
+	slice, err := modelSlice(model)
+	if err != nil {
+		return nil, err
+	}
 
-	query := p.db.Model(model)
+	query := p.db.ModelContext(ctx, slice.Interface()).OrderExpr("?TablePKs")
 
 	// Apply pagination if limit is provided
 	if limit > 0 {
@@ -108,12 +114,65 @@ func (p *PostgresDatabase) GetAll(ctx context.Context, model interface{}, limit,
 		query = query.Offset(offset)
 	}
 
-	err := query.Select()
-	if err != nil {
+	if err := query.Select(); err != nil {
 		return nil, fmt.Errorf("failed to retrieve all data: %v", err)
 	}
 
-	return results, nil
+	return sliceElements(slice), nil
+}
+
+// GetAllAfter retrieves up to limit records of the given model whose primary
+// key comes after afterKey (all records when it is empty), in primary key
+// order. Unlike offsets, this keyset pagination stays fast on large tables and
+// does not skip or repeat records when rows are inserted between pages. The
+// model must have a single-column primary key.
+func (p *PostgresDatabase) GetAllAfter(ctx context.Context, model interface{}, afterKey interface{}, limit int) ([]interface{}, error) {
+	slice, err := modelSlice(model)
+	if err != nil {
+		return nil, err
+	}
+
+	query := p.db.ModelContext(ctx, slice.Interface()).OrderExpr("?TablePKs")
+	if afterKey != nil && afterKey != "" {
+		query = query.Where("?TablePKs > ?", afterKey)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve data: %v", err)
+	}
+
+	return sliceElements(slice), nil
+}
+
+// modelSlice returns a pointer to the slice records of model are loaded into:
+// model itself when it points to a slice, or a new slice of the struct it points to
+func modelSlice(model interface{}) (reflect.Value, error) {
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Ptr {
+		return reflect.Value{}, fmt.Errorf("model must be a pointer, got %T", model)
+	}
+
+	if value.Elem().Kind() == reflect.Slice {
+		return value, nil
+	}
+	return reflect.New(reflect.SliceOf(value.Elem().Type())), nil
+}
+
+// sliceElements returns pointers to the elements of the slice slicePtr points to
+func sliceElements(slicePtr reflect.Value) []interface{} {
+	slice := slicePtr.Elem()
+	results := make([]interface{}, 0, slice.Len())
+	for i := 0; i < slice.Len(); i++ {
+		element := slice.Index(i)
+		if element.Kind() != reflect.Ptr {
+			element = element.Addr()
+		}
+		results = append(results, element.Interface())
+	}
+	return results
 }
 
 // CreateSchema creates the database schema by generating tables for all models passed
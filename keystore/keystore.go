@@ -0,0 +1,177 @@
+package keystore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+)
+
+// ErrKeyNotFound is returned when a key ID does not exist or cannot be promoted
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// List returns every stored signing key, newest first
+func List(db *pg.DB) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := db.Model(&keys).Order("created_at DESC").Select()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %v", err)
+	}
+	return keys, nil
+}
+
+// Generate creates a new pending signing key. Pending keys are published in the
+// JWKS straight away so verifiers can cache them before they start signing.
+func Generate(db *pg.DB, alg string) (*models.SigningKey, error) {
+	key, err := auth.GenerateSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := key.MarshalPrivateKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := auth.EncryptSecret(keyPEM, []byte(key.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.SigningKey{
+		ID:           key.ID,
+		Algorithm:    key.Algorithm,
+		EncryptedKey: encrypted,
+		Status:       models.SigningKeyStatusPending,
+		CreatedAt:    time.Now(),
+	}
+	if _, err := db.Model(record).Insert(); err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %v", err)
+	}
+
+	return record, nil
+}
+
+// Promote makes the key the active signing key. The previously active key is
+// retired and stays valid for verification for the maximum token lifetime.
+func Promote(db *pg.DB, kid string) error {
+	return db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		now := time.Now()
+		verifyUntil := now.Add(auth.MaxTokenLifetime())
+
+		_, err := tx.Model((*models.SigningKey)(nil)).
+			Set("status = ?", models.SigningKeyStatusRetired).
+			Set("retired_at = ?", now).
+			Set("verify_until = ?", verifyUntil).
+			Where("status = ?", models.SigningKeyStatusActive).
+			Where("id != ?", kid).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to retire active signing key: %v", err)
+		}
+
+		res, err := tx.Model((*models.SigningKey)(nil)).
+			Set("status = ?", models.SigningKeyStatusActive).
+			Set("activated_at = ?", now).
+			Set("retired_at = NULL").
+			Set("verify_until = NULL").
+			Where("id = ?", kid).
+			Where("status IN (?, ?)", models.SigningKeyStatusPending, models.SigningKeyStatusActive).
+			Update()
+		if err != nil {
+			return fmt.Errorf("failed to activate signing key: %v", err)
+		}
+		if res.RowsAffected() == 0 {
+			return ErrKeyNotFound
+		}
+
+		return nil
+	})
+}
+
+// Prune expires retired keys whose verification window has passed and returns
+// how many keys were expired
+func Prune(db *pg.DB) (int, error) {
+	res, err := db.Model((*models.SigningKey)(nil)).
+		Set("status = ?", models.SigningKeyStatusExpired).
+		Where("status = ?", models.SigningKeyStatusRetired).
+		Where("verify_until < ?", time.Now()).
+		Update()
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune signing keys: %v", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+// Load reads the usable keys from the database into auth.DefaultKeyRing.
+// Without an active key in the database the key from the environment keeps
+// signing. Keys from the environment always stay trusted for verification.
+func Load(db *pg.DB) error {
+	var records []models.SigningKey
+	err := db.Model(&records).
+		WhereOr("status IN (?, ?)", models.SigningKeyStatusPending, models.SigningKeyStatusActive).
+		WhereOrGroup(func(q *pg.Query) (*pg.Query, error) {
+			return q.Where("status = ?", models.SigningKeyStatusRetired).Where("verify_until > ?", time.Now()), nil
+		}).
+		Select()
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	active := auth.EnvSigningKey()
+	verification := auth.EnvVerificationKeys()
+
+	for _, record := range records {
+		key, err := decryptSigningKey(record)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt signing key %s: %v", record.ID, err)
+		}
+
+		if record.Status == models.SigningKeyStatusActive {
+			active = key
+		} else {
+			verification = append(verification, key)
+		}
+	}
+
+	auth.DefaultKeyRing.Replace(active, verification)
+	return nil
+}
+
+// decryptSigningKey turns a stored record back into a signing key
+func decryptSigningKey(record models.SigningKey) (*auth.SigningKey, error) {
+	keyPEM, err := auth.DecryptSecret(record.EncryptedKey, []byte(record.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := auth.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewSigningKey(record.ID, private)
+}
+
+// StartRefresher periodically reloads the key ring so that promotions made by
+// another instance (or the CLI) are picked up without a restart
+func StartRefresher(ctx context.Context, db *pg.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Load(db); err != nil {
+				log.Printf("Failed to refresh signing keys: %v", err)
+			}
+		}
+	}
+}
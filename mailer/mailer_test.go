@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMessageBytesTextOnly(t *testing.T) {
+	msg := &Message{
+		From:    "no-reply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Vérifiez votre adresse",
+		Text:    "Hello,\nopen https://example.com/verify-email?token=abc to continue.",
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := parsed.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	// Line breaks are sent as CRLF
+	if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != msg.Text {
+		t.Errorf("body = %q, want %q", got, msg.Text)
+	}
+}
+
+func TestMessageBytesWithHTML(t *testing.T) {
+	msg := &Message{
+		From:    "no-reply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Reset your password",
+		Text:    "Reset it at https://example.com/reset",
+		HTML:    `<p>Reset it <a href="https://example.com/reset">here</a></p>`,
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", parsed.Header.Get("Content-Type"), err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		// The multipart reader decodes quoted-printable parts itself
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		if got := part.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, want.contentType)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		if string(body) != want.body {
+			t.Errorf("part body = %q, want %q", body, want.body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got more (%v)", err)
+	}
+}
+
+func TestMessageBytesStripsHeaderInjection(t *testing.T) {
+	msg := &Message{
+		From:    "no-reply@example.com",
+		To:      []string{"user@example.com\r\nBcc: attacker@example.com"},
+		Subject: "Hello",
+		Text:    "Hi",
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	if strings.Contains(string(raw), "\r\nBcc:") {
+		t.Errorf("header injection not stripped:\n%s", raw)
+	}
+}
+
+func TestMessageBytesNoRecipients(t *testing.T) {
+	if _, err := (&Message{Text: "Hi"}).Bytes(); err != ErrNoRecipients {
+		t.Errorf("err = %v, want ErrNoRecipients", err)
+	}
+}
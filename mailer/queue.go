@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrQueueFull is returned when a message cannot be queued without blocking
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends messages in the background so request handlers never wait for
+// mail delivery. Failed deliveries are retried with exponential backoff.
+// Queued messages live in memory only and are lost on restart.
+type Queue struct {
+	MaxAttempts int           // Deliveries attempted before a message is dropped
+	Backoff     time.Duration // Delay before the first retry, doubled on every retry
+
+	mailer Mailer
+	jobs   chan queuedMessage
+}
+
+// queuedMessage is a message waiting for its next delivery attempt
+type queuedMessage struct {
+	msg      *Message
+	attempts int
+}
+
+// NewQueue creates a queue holding up to size messages and delivering them with m
+func NewQueue(m Mailer, size int) *Queue {
+	return &Queue{
+		MaxAttempts: 5,
+		Backoff:     10 * time.Second,
+		mailer:      m,
+		jobs:        make(chan queuedMessage, size),
+	}
+}
+
+// Send queues the message and returns immediately, so a Queue can be used
+// wherever a Mailer is expected
+func (q *Queue) Send(ctx context.Context, msg *Message) error {
+	if len(msg.To) == 0 {
+		return ErrNoRecipients
+	}
+	return q.enqueue(queuedMessage{msg: msg})
+}
+
+// enqueue adds a message without blocking
+func (q *Queue) enqueue(job queuedMessage) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start delivers queued messages with the given number of workers until ctx is done
+func (q *Queue) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+// work delivers messages one at a time
+func (q *Queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.jobs:
+			q.deliver(ctx, job)
+		}
+	}
+}
+
+// deliver attempts to send a message and schedules a retry on failure
+func (q *Queue) deliver(ctx context.Context, job queuedMessage) {
+	job.attempts++
+	err := q.mailer.Send(ctx, job.msg)
+	if err == nil {
+		return
+	}
+
+	if job.attempts >= q.MaxAttempts || err == ErrNoRecipients {
+		log.Printf("Dropping email %q to %v after %d attempts: %v", job.msg.Subject, job.msg.To, job.attempts, err)
+		return
+	}
+
+	delay := q.Backoff << (job.attempts - 1)
+	log.Printf("Error sending email %q to %v (attempt %d), retrying in %s: %v", job.msg.Subject, job.msg.To, job.attempts, delay, err)
+
+	time.AfterFunc(delay, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := q.enqueue(job); err != nil {
+			log.Printf("Dropping email %q to %v: %v", job.msg.Subject, job.msg.To, err)
+		}
+	})
+}
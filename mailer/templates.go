@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+// Templates renders localized messages. Every message is one file per locale,
+// <locale>/<name>.html, defining three templates: "subject" and "text" are
+// executed as plain text, "content" as HTML inside the shared layout.html.
+type Templates struct {
+	DefaultLocale string // Used when none of the requested locales has the message
+
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// EmbeddedTemplates returns the templates shipped with the service
+func EmbeddedTemplates() (*Templates, error) {
+	sub, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplates(sub)
+}
+
+// NewTemplates parses layout.html and every <locale>/<name>.html in fsys
+func NewTemplates(fsys fs.FS) (*Templates, error) {
+	layout, err := htmltemplate.ParseFS(fsys, "layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(fsys, "*/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		DefaultLocale: "en",
+		html:          map[string]*htmltemplate.Template{},
+		text:          map[string]*texttemplate.Template{},
+	}
+	for _, file := range files {
+		key := strings.TrimSuffix(file, ".html")
+
+		html, err := htmltemplate.Must(layout.Clone()).ParseFS(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		text, err := texttemplate.ParseFS(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		t.html[key] = html
+		t.text[key] = text
+	}
+
+	return t, nil
+}
+
+// Render renders the named message in the best matching locale. lang is a
+// locale ("fr-CA") or an Accept-Language header value; the primary language
+// ("fr") and then the default locale are tried when there is no exact match.
+func (t *Templates) Render(lang, name string, data interface{}) (*Message, error) {
+	key, ok := t.lookup(lang, name)
+	if !ok {
+		return nil, fmt.Errorf("mail template %q not found", name)
+	}
+
+	subject, err := executeText(t.text[key], "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := executeText(t.text[key], "text", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := t.html[key].ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject: subject,
+		Text:    text,
+		HTML:    html.String(),
+	}, nil
+}
+
+// lookup finds the template key for the first available requested locale
+func (t *Templates) lookup(lang, name string) (string, bool) {
+	var candidates []string
+	for _, tag := range strings.Split(lang, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(tag), ";")
+		if tag == "" || tag == "*" || strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+
+		tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+		primary, _, _ := strings.Cut(tag, "-")
+		candidates = append(candidates, tag, primary)
+	}
+	candidates = append(candidates, strings.ToLower(t.DefaultLocale))
+
+	for _, locale := range candidates {
+		key := path.Join(locale, name)
+		if _, ok := t.html[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// executeText executes a plain text template and trims surrounding whitespace
+func executeText(tmpl *texttemplate.Template, name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
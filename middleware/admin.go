@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminTokenMiddleware protects operator endpoints with the static token in
+// ADMIN_API_TOKEN, passed in the X-Admin-Token header. The endpoints are
+// disabled entirely when no token is configured.
+func AdminTokenMiddleware() fiber.Handler {
+	adminToken := config.GetEnv("ADMIN_API_TOKEN", "")
+
+	return func(c *fiber.Ctx) error {
+		if adminToken == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Not found",
+			})
+		}
+
+		provided := c.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(adminToken)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid admin token",
+			})
+		}
+
+		c.Locals("subject_type", SubjectAdmin)
+		return c.Next()
+	}
+}
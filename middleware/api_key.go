@@ -0,0 +1,189 @@
+package middlewares
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/go-pg/pg/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// apiKeyScheme is the Authorization scheme of personal API keys
+const apiKeyScheme = "ApiKey "
+
+// apiKeyLastUsedInterval limits how often the last use of a key is written
+const apiKeyLastUsedInterval = time.Minute
+
+// apiKeyFromRequest returns the API key of the request, from the X-API-Key
+// header or an "Authorization: ApiKey <key>" header, or an empty string
+func apiKeyFromRequest(c *fiber.Ctx) string {
+	if key := c.Get("X-API-Key"); key != "" {
+		return strings.TrimSpace(key)
+	}
+
+	authHeader := c.Get("Authorization")
+	if len(authHeader) > len(apiKeyScheme) && strings.EqualFold(authHeader[:len(apiKeyScheme)], apiKeyScheme) {
+		return strings.TrimSpace(authHeader[len(apiKeyScheme):])
+	}
+	return ""
+}
+
+// authenticateAPIKey validates a personal API key and stores its user in the
+// context like TokenAuthMiddleware does for access tokens. The key holds no
+// roles, and only those of its scopes the user still has as permissions.
+func authenticateAPIKey(c *fiber.Ctx, key string) error {
+	if !auth.LooksLikeAPIKey(key) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired API key",
+		})
+	}
+
+	var apiKey models.APIKey
+	err := config.DB.Model(&apiKey).
+		Where("key_hash = ?", auth.HashOpaqueToken(key)).
+		Where("expires_at > ?", time.Now()).
+		Select()
+	if err == pg.ErrNoRows {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired API key",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying API key: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	var user models.User
+	err = config.DB.Model(&user).Where("id = ?", apiKey.UserID).Select()
+	if err == pg.ErrNoRows {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired API key",
+		})
+	}
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	if !user.Active() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account deactivated",
+		})
+	}
+
+	// The key keeps the organization it was created in, while the user is an active member
+	orgRole := ""
+	if apiKey.OrgID != "" {
+		var membership models.Membership
+		err := config.DB.Model(&membership).Where("org_id = ?", apiKey.OrgID).Where("user_id = ?", user.ID).Select()
+		if err == pg.ErrNoRows || (err == nil && !membership.Active()) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "The API key's organization membership was removed or suspended",
+			})
+		}
+		if err != nil {
+			log.Printf("Error querying membership: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+		orgRole = membership.Role
+	}
+
+	permissions, err := apiKeyPermissions(&apiKey)
+	if err != nil {
+		log.Printf("Error querying API key permissions: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Internal server error",
+		})
+	}
+
+	recordAPIKeyUse(&apiKey, c.IP())
+
+	// Handlers reading claims see the key's user and effective scope
+	claims := jwt.MapClaims{
+		"sub":            user.ID,
+		"user_id":        user.ID,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"scope":          strings.Join(permissions, " "),
+		"api_key_id":     apiKey.ID,
+	}
+
+	c.Locals("user_id", user.ID)
+	c.Locals("email", user.Email)
+	c.Locals("email_verified", user.EmailVerified)
+	c.Locals("roles", []string{})
+	c.Locals("permissions", permissions)
+	c.Locals("org_id", apiKey.OrgID)
+	c.Locals("org_role", orgRole)
+	c.Locals("client_id", "")
+	c.Locals("subject_type", SubjectUser)
+	c.Locals("api_key_id", apiKey.ID)
+	c.Locals("claims", claims)
+
+	return c.Next()
+}
+
+// apiKeyPermissions returns the scopes of the key that its user currently holds
+// through their roles
+func apiKeyPermissions(apiKey *models.APIKey) ([]string, error) {
+	permissions := []string{}
+	if len(apiKey.Scopes) == 0 {
+		return permissions, nil
+	}
+
+	err := config.DB.Model((*models.Permission)(nil)).
+		ColumnExpr("DISTINCT permission.name").
+		Join("JOIN role_permissions AS rp ON rp.permission_id = permission.id").
+		Join("JOIN user_roles AS ur ON ur.role_id = rp.role_id").
+		Where("ur.user_id = ?", apiKey.UserID).
+		Where("permission.name IN (?)", pg.In(apiKey.Scopes)).
+		Order("permission.name ASC").
+		Select(&permissions)
+	return permissions, err
+}
+
+// recordAPIKeyUse updates the last use of the key, at most once per
+// apiKeyLastUsedInterval. Failures are only logged.
+func recordAPIKeyUse(apiKey *models.APIKey, ip string) {
+	now := time.Now()
+	_, err := config.DB.Model((*models.APIKey)(nil)).
+		Set("last_used_at = ?", now).
+		Set("last_used_ip = ?", ip).
+		Where("id = ?", apiKey.ID).
+		Where("last_used_at IS NULL OR last_used_at < ?", now.Add(-apiKeyLastUsedInterval)).
+		Update()
+	if err != nil {
+		log.Printf("Error recording API key use: %v", err)
+	}
+}
+
+// IsAPIKey reports whether the caller authenticated with a personal API key
+// rather than an access token
+func IsAPIKey(c *fiber.Ctx) bool {
+	apiKeyID, _ := c.Locals("api_key_id").(string)
+	return apiKeyID != ""
+}
+
+// RequireAccessToken rejects personal API keys on routes acting on the access
+// token itself, such as logout. It must run after TokenAuthMiddleware.
+func RequireAccessToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IsAPIKey(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This endpoint requires an access token",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -1,10 +1,12 @@
 package middlewares
 
 import (
+	"log"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/drive-deep/auth-microservices/auth"
+	"github.com/drive-deep/auth-microservices/config"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -17,8 +19,27 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
+// Caller types stored in c.Locals("subject_type") by TokenAuthMiddleware and
+// AdminTokenMiddleware
+const (
+	SubjectUser    = "user"    // A user, possibly through an OAuth client
+	SubjectService = "service" // An OAuth client acting on its own behalf (client credentials)
+	SubjectAdmin   = "admin"   // The operator, with the admin API token
+)
+
+// TokenAuthMiddleware validates the bearer token and stores the caller in the
+// context: "user_id", "email" and "email_verified" for users, "roles",
+// "permissions", "org_id" and "org_role" for first-party user tokens,
+// "client_id" for tokens issued to an OAuth client, "subject_type" telling
+// users and services apart, and "claims". Personal API keys ("Authorization:
+// ApiKey <key>" or "X-API-Key") are accepted too and also set "api_key_id".
 func TokenAuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		// Personal API keys are looked up instead of validated as JWTs
+		if key := apiKeyFromRequest(c); key != "" {
+			return authenticateAPIKey(c, key)
+		}
+
 		// Get the token from the Authorization header (bearer <token>)
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -43,15 +64,133 @@ func TokenAuthMiddleware() fiber.Handler {
 		// Correctly access the claims from the MapClaims
 		mapClaims := *claims // Dereference the pointer
 
+		// Reject tokens that were revoked (logout) before their expiry
+		if config.Redis != nil {
+			if err := auth.CheckRevoked(c.Context(), config.Redis, mapClaims); err != nil {
+				if err == auth.ErrTokenRevoked {
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+						"error": err.Error(),
+					})
+				}
+				log.Printf("Error checking token revocation: %v", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Internal server error",
+				})
+			}
+
+			// Tokens issued before a change of the user's roles must be refreshed
+			if err := auth.CheckAuthzVersion(c.Context(), config.Redis, mapClaims); err != nil {
+				if err == auth.ErrStaleAuthorization {
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+						"error": err.Error(),
+					})
+				}
+				log.Printf("Error checking authorization version: %v", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Internal server error",
+				})
+			}
+
+			// Deactivated users are refused until their tokens expire
+			if err := auth.CheckUserActive(c.Context(), config.Redis, mapClaims); err != nil {
+				if err == auth.ErrUserDeactivated {
+					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+						"error": "Account deactivated",
+					})
+				}
+				log.Printf("Error checking account status: %v", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Internal server error",
+				})
+			}
+		}
+
 		// Correctly access the claims from the MapClaims
 		userID, _ := mapClaims["user_id"].(string)
 		email, _ := mapClaims["email"].(string)
+		clientID, _ := mapClaims["client_id"].(string)
+		orgID, _ := mapClaims[auth.ClaimOrgID].(string)
+		orgRole, _ := mapClaims[auth.ClaimOrgRole].(string)
+		emailVerified, _ := mapClaims["email_verified"].(bool)
+
+		// Service tokens carry no user, their subject is the client itself
+		subjectType := SubjectUser
+		if userID == "" && clientID != "" {
+			subjectType = SubjectService
+		}
 
 		// Store the claims in the context
 		c.Locals("user_id", userID)
 		c.Locals("email", email)
+		c.Locals("email_verified", emailVerified)
+		c.Locals("roles", auth.ClaimStrings(mapClaims, auth.ClaimRoles))
+		c.Locals("permissions", auth.ClaimStrings(mapClaims, auth.ClaimPermissions))
+		c.Locals("org_id", orgID)
+		c.Locals("org_role", orgRole)
+		c.Locals("client_id", clientID)
+		c.Locals("subject_type", subjectType)
+		c.Locals("claims", mapClaims)
 
 		// If the token is valid, pass the request to the next handler
 		return c.Next()
 	}
 }
+
+// RequireFirstParty rejects tokens issued to OAuth clients and personal API
+// keys, for routes that manage the user's credentials and must only be used by
+// the service's own frontends. It must run after TokenAuthMiddleware.
+func RequireFirstParty() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if clientID, _ := c.Locals("client_id").(string); clientID != "" || IsAPIKey(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This endpoint requires a first-party user token",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users whose email is not verified yet, for
+// routes that should stay closed under the restrict verification policy
+// (EMAIL_VERIFICATION_POLICY). It must run after TokenAuthMiddleware.
+func RequireVerifiedEmail() fiber.Handler {
+	policy := strings.ToLower(config.GetEnv("EMAIL_VERIFICATION_POLICY", "block"))
+
+	return func(c *fiber.Ctx) error {
+		if policy == "off" || IsService(c) {
+			return c.Next()
+		}
+
+		if verified, _ := c.Locals("email_verified").(bool); !verified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Email not verified",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// IsAdmin reports whether the caller is the operator with the admin API token
+// (X-Admin-Token), the only caller not confined to an organization
+func IsAdmin(c *fiber.Ctx) bool {
+	return c.Locals("subject_type") == SubjectAdmin
+}
+
+// IsService reports whether the authenticated caller is a service (client
+// credentials token) rather than a user
+func IsService(c *fiber.Ctx) bool {
+	return c.Locals("subject_type") == SubjectService
+}
+
+// RequireUser rejects service tokens on routes that act on the caller's user
+// account. It must run after TokenAuthMiddleware.
+func RequireUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IsService(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This endpoint requires a user token",
+			})
+		}
+		return c.Next()
+	}
+}
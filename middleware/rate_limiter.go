@@ -1,45 +1,48 @@
 package middlewares
 
 import (
-	"os"
+	"log"
+	"math"
+	"strconv"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/drive-deep/auth-microservices/config"
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/net/context"
 )
 
-var ctx = context.Background()
+// rateLimitWindow is the period requests are counted over
+const rateLimitWindow = time.Minute
 
-// RateLimitMiddleware is a middleware that limits the number of requests per minute
+// RateLimitMiddleware limits the number of requests per minute and client IP
+// to RATE_LIMIT_PER_MINUTE (default 100, 0 disables the limit). It caps the
+// guesses a single IP can spread over many accounts, which the per-account
+// lockout does not see.
 func RateLimitMiddleware(c *fiber.Ctx) error {
-	// Set up Redis client (assumes Redis is running on localhost:6379)
-	client := redis.NewClient(&redis.Options{
-		Addr: os.Getenv("REDIS_HOST"), // Change this if Redis is hosted elsewhere
-	})
-
-	// Use the client's Get function to fetch the count of requests for the IP
-	ip := c.IP()
-	key := "rate_limit:" + ip
-	ttl := 60 * time.Second // Time to live (1 minute)
+	limit := config.GetEnvInt("RATE_LIMIT_PER_MINUTE", 100)
+	if limit <= 0 {
+		return c.Next()
+	}
 
-	// Increment the request counter for this IP
-	requestCount, err := client.Incr(ctx, key).Result()
+	// Count the requests of this IP in the current window
+	key := "rate_limit:" + c.IP()
+	requestCount, err := config.Redis.Incr(c.Context(), key, rateLimitWindow)
 	if err != nil {
+		log.Printf("Error counting requests: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Internal server error",
 		})
 	}
 
-	// If the count is 1, set the TTL (time-to-live) for the key
-	if requestCount == 1 {
-		client.Expire(ctx, key, ttl)
-	}
-
-	// If the request count exceeds the limit (e.g., 100 requests per minute), return an error
-	if requestCount > 100 {
+	if requestCount > int64(limit) {
+		retryAfter := rateLimitWindow
+		if ttl, err := config.Redis.TTL(c.Context(), key); err == nil && ttl > 0 {
+			retryAfter = ttl
+		}
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
 		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-			"error": "Rate limit exceeded. Try again later.",
+			"error":       "Rate limit exceeded. Try again later.",
+			"retry_after": seconds,
 		})
 	}
 
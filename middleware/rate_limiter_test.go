@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/drive-deep/auth-microservices/config"
+	"github.com/drive-deep/auth-microservices/redis"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	server := miniredis.RunT(t)
+	previous := config.Redis
+	config.Redis = redis.NewRedisClient(server.Addr(), "", 0)
+	t.Cleanup(func() {
+		config.Redis.Close()
+		config.Redis = previous
+	})
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "3")
+
+	app := fiber.New()
+	app.Use(RateLimitMiddleware)
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1)
+		if err != nil {
+			t.Fatalf("GET /: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp := get(); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i+1, resp.StatusCode)
+		}
+	}
+	resp := get()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit = %d, want 429", resp.StatusCode)
+	}
+	if retryAfter := resp.Header.Get(fiber.HeaderRetryAfter); retryAfter == "" || retryAfter == "0" {
+		t.Errorf("Retry-After = %q, want the rest of the window", retryAfter)
+	}
+
+	// The counter expires with the window
+	server.FastForward(rateLimitWindow)
+	if resp := get(); resp.StatusCode != http.StatusOK {
+		t.Errorf("request in the next window = %d, want 200", resp.StatusCode)
+	}
+
+	t.Setenv("RATE_LIMIT_PER_MINUTE", "0")
+	for i := 0; i < 5; i++ {
+		if resp := get(); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d without a limit = %d, want 200", i+1, resp.StatusCode)
+		}
+	}
+}
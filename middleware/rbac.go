@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// RequireRole rejects callers that have none of the roles. It must run after
+// TokenAuthMiddleware.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		held, _ := c.Locals("roles").([]string)
+		for _, role := range roles {
+			if contains(held, role) {
+				return c.Next()
+			}
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient role",
+		})
+	}
+}
+
+// RequirePermission rejects callers that lack any of the permissions. Users get
+// their permissions from their roles; services (client credentials tokens) need
+// each permission in their granted scope. It must run after TokenAuthMiddleware.
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		held, _ := c.Locals("permissions").([]string)
+		if IsService(c) {
+			claims, _ := c.Locals("claims").(jwt.MapClaims)
+			scope, _ := claims["scope"].(string)
+			held = strings.Fields(scope)
+		}
+
+		for _, permission := range permissions {
+			if !contains(held, permission) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error":      "Insufficient permissions",
+					"permission": permission,
+				})
+			}
+		}
+		return c.Next()
+	}
+}
+
+// RequireOrg rejects tokens that are not scoped to an organization, for routes
+// acting on the caller's current organization. It must run after
+// TokenAuthMiddleware.
+func RequireOrg() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if orgID, _ := c.Locals("org_id").(string); orgID == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This endpoint requires a token scoped to an organization",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireOrgRole rejects callers whose role in their current organization is
+// none of the roles. It must run after TokenAuthMiddleware.
+func RequireOrgRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgRole, _ := c.Locals("org_role").(string)
+		if orgRole != "" && contains(roles, orgRole) {
+			return c.Next()
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient organization role",
+		})
+	}
+}
+
+// contains reports whether values holds want
+func contains(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a long-lived personal credential for scripts and CI jobs. The key is
+// only shown once at creation and stored as a SHA-256 hash. It acts as its user
+// with at most the permissions in Scopes, within the organization the user was
+// working in when the key was created.
+type APIKey struct {
+	tableName struct{} `pg:"api_keys"`
+
+	ID         string     `json:"id" pg:"id,pk"`                            // Primary key as UUID
+	UserID     string     `json:"user_id" pg:"user_id,notnull"`             // Owner of the key
+	OrgID      string     `json:"org_id,omitempty" pg:"org_id"`             // Organization the key is scoped to, empty for none
+	Name       string     `json:"name" pg:"name,notnull"`                   // Label chosen by the user
+	Prefix     string     `json:"prefix" pg:"prefix,notnull"`               // First characters of the key, to recognize it
+	KeyHash    string     `json:"-" pg:"key_hash,unique,notnull"`           // SHA-256 hash of the key
+	Scopes     []string   `json:"scopes" pg:"scopes,array"`                 // Permissions the key may use, if the user still holds them
+	ExpiresAt  time.Time  `json:"expires_at" pg:"expires_at"`               // Date and time the key stops working
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" pg:"last_used_at"` // Date and time the key was last used (minute precision)
+	LastUsedIP string     `json:"last_used_ip,omitempty" pg:"last_used_ip"` // Client IP of the last use
+	CreatedAt  time.Time  `json:"created_at" pg:"created_at"`               // Date and time of creation
+}
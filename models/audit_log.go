@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records an administrative change: who did what to which object
+type AuditLog struct {
+	tableName struct{} `pg:"audit_log"`
+
+	ID         string                 `json:"id" pg:"id,pk"`                             // Primary key as UUID
+	Actor      string                 `json:"actor" pg:"actor,notnull"`                  // User ID of the caller, or "admin" for the admin API token
+	ActorIP    string                 `json:"actor_ip" pg:"actor_ip"`                    // Client IP of the request
+	Action     string                 `json:"action" pg:"action,notnull"`                // What was done, e.g. "role.create"
+	TargetType string                 `json:"target_type" pg:"target_type,notnull"`      // Kind of object changed, e.g. "role"
+	TargetID   string                 `json:"target_id" pg:"target_id,notnull"`          // ID of the object changed
+	Details    map[string]interface{} `json:"details,omitempty" pg:"details,type:jsonb"` // Action specific data, e.g. the previous values
+	CreatedAt  time.Time              `json:"created_at" pg:"created_at"`                // Date and time of the change
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+// Statuses of an invitation. A pending invitation past its expiry is reported
+// as expired.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// Invitation invites an email address to join an organization with a role.
+// The invite link carries a signed token whose ID must match TokenID, so
+// resending the invitation invalidates the previous link.
+type Invitation struct {
+	tableName struct{} `pg:"invitations"`
+
+	ID         string     `json:"id" pg:"id,pk"`                          // Primary key as UUID
+	OrgID      string     `json:"org_id" pg:"org_id,notnull"`             // Organization to join
+	Email      string     `json:"email" pg:"email,notnull"`               // Address the invitation was sent to
+	Role       string     `json:"role" pg:"role,notnull"`                 // Membership role granted on acceptance, one of OrgRoles
+	InvitedBy  string     `json:"invited_by" pg:"invited_by"`             // User who sent the invitation
+	Status     string     `json:"status" pg:"status,notnull"`             // One of the invitation statuses
+	TokenID    string     `json:"-" pg:"token_id"`                        // ID (jti) of the token in the current invite link
+	ExpiresAt  time.Time  `json:"expires_at" pg:"expires_at"`             // Date and time the current invite link expires
+	AcceptedBy string     `json:"accepted_by,omitempty" pg:"accepted_by"` // User who accepted the invitation
+	AcceptedAt *time.Time `json:"accepted_at,omitempty" pg:"accepted_at"` // Date and time of acceptance
+	CreatedAt  time.Time  `json:"created_at" pg:"created_at"`             // Date and time of creation
+	UpdatedAt  time.Time  `json:"updated_at" pg:"updated_at"`             // Date and time of the last resend, revocation or acceptance
+}
+
+// CurrentStatus returns the status of the invitation, expired when it is still
+// pending past its expiry
+func (i *Invitation) CurrentStatus(now time.Time) string {
+	if i.Status == InvitationPending && now.After(i.ExpiresAt) {
+		return InvitationExpired
+	}
+	return i.Status
+}
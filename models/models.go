@@ -4,5 +4,48 @@ package models
 func GetAllModels() []interface{} {
 	return []interface{}{
 		(*User)(nil), // Add other models here as needed
+		(*RefreshToken)(nil),
+		(*SigningKey)(nil),
+		(*OAuthClient)(nil),
+		(*UserMFA)(nil),
+		(*WebAuthnCredential)(nil),
+		(*Role)(nil),
+		(*Permission)(nil),
+		(*RolePermission)(nil),
+		(*UserRole)(nil),
+		(*AuditLog)(nil),
+		(*Organization)(nil),
+		(*Membership)(nil),
+		(*Invitation)(nil),
+		(*APIKey)(nil),
+	}
+}
+
+// GetAllIndexes returns the indexes created at startup in addition to primary
+// keys and unique columns, as idempotent SQL statements
+func GetAllIndexes() []string {
+	return []string{
+		// User listing: keyset pagination on each sort, prefix search on email and names
+		`CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id)`,
+		`CREATE INDEX IF NOT EXISTS users_email_id_idx ON users (email, id)`,
+		`CREATE INDEX IF NOT EXISTS users_last_name_id_idx ON users ((coalesce(last_name, '')), id)`,
+		`CREATE INDEX IF NOT EXISTS users_lower_email_prefix_idx ON users (lower(email) text_pattern_ops)`,
+		`CREATE INDEX IF NOT EXISTS users_lower_first_name_prefix_idx ON users (lower(first_name) text_pattern_ops)`,
+		`CREATE INDEX IF NOT EXISTS users_lower_last_name_prefix_idx ON users (lower(last_name) text_pattern_ops)`,
+		`CREATE INDEX IF NOT EXISTS users_status_idx ON users (status)`,
+
+		// Filters by role and organization, whose primary keys start with the user
+		// and the organization respectively
+		`CREATE INDEX IF NOT EXISTS user_roles_role_id_idx ON user_roles (role_id)`,
+		`CREATE INDEX IF NOT EXISTS memberships_user_id_idx ON memberships (user_id)`,
+	}
+}
+
+// GetColumnBackfills returns, by "table.column", the statement bringing the
+// existing rows up to date when the column is added to an existing table
+func GetColumnBackfills() map[string]string {
+	return map[string]string{
+		// Accounts created before email verification existed keep logging in
+		"users.email_verified": `UPDATE users SET email_verified = TRUE, email_verified_at = created_at`,
 	}
 }
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuthClient represents an application registered with the authorization server.
+// The client secret is stored as a SHA-256 hash; it is only shown once at creation.
+// Public clients (SPAs, mobile apps) have no secret and must use PKCE.
+// Confidential clients can also authenticate as themselves (client credentials
+// grant) to obtain service tokens for service-to-service calls.
+type OAuthClient struct {
+	tableName struct{} `pg:"oauth_clients"` // go-pg would otherwise name the table o_auth_clients
+
+	ID            string    `json:"client_id" pg:"id,pk"`                      // Client identifier
+	Name          string    `json:"name" pg:"name,notnull"`                    // Human readable name
+	SecretHash    string    `json:"-" pg:"secret_hash"`                        // SHA-256 hash of the client secret
+	Public        bool      `json:"public" pg:"public,use_zero,default:false"` // Public clients cannot keep a secret
+	RedirectURIs  []string  `json:"redirect_uris" pg:"redirect_uris,array"`    // Allowed redirect URIs (exact match)
+	AllowedScopes []string  `json:"allowed_scopes" pg:"allowed_scopes,array"`  // Scopes the client may request, empty allows any
+	Audience      string    `json:"audience,omitempty" pg:"audience"`          // "aud" of service tokens issued to the client
+	CreatedAt     time.Time `json:"created_at" pg:"created_at"`                // Date and time of registration
+	UpdatedAt     time.Time `json:"updated_at" pg:"updated_at"`                // Date and time of the last update
+}
+
+// HasRedirectURI reports whether the redirect URI is registered for the client
+func (c *OAuthClient) HasRedirectURI(redirectURI string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope reports whether every scope in the space-delimited scope string
+// may be requested by the client
+func (c *OAuthClient) AllowsScope(scope string) bool {
+	if len(c.AllowedScopes) == 0 {
+		return true
+	}
+
+	allowed := make(map[string]bool, len(c.AllowedScopes))
+	for _, s := range c.AllowedScopes {
+		allowed[s] = true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !allowed[s] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+// Roles of a member within an organization, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"  // Manages the organization and its owners
+	OrgRoleAdmin  = "admin"  // Manages the members
+	OrgRoleMember = "member" // Uses the organization's workspace
+)
+
+// OrgRoles lists the valid membership roles
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+// Statuses of a membership. Suspended members keep their membership but get
+// no access to the organization.
+const (
+	MembershipStatusActive    = "active"
+	MembershipStatusSuspended = "suspended"
+)
+
+// Organization is a customer workspace (tenant). Users belong to organizations
+// through memberships, and access tokens are scoped to one of them.
+type Organization struct {
+	tableName struct{} `pg:"organizations"`
+
+	ID        string    `json:"id" pg:"id,pk"`              // Primary key as UUID
+	Name      string    `json:"name" pg:"name,notnull"`     // Display name
+	CreatedBy string    `json:"created_by" pg:"created_by"` // User who created the organization
+	CreatedAt time.Time `json:"created_at" pg:"created_at"` // Date and time of creation
+	UpdatedAt time.Time `json:"updated_at" pg:"updated_at"` // Date and time of the last update
+}
+
+// Membership makes a user a member of an organization with a role
+type Membership struct {
+	tableName struct{} `pg:"memberships"`
+
+	OrgID       string     `json:"org_id" pg:"org_id,pk"`                    // Organization
+	UserID      string     `json:"user_id" pg:"user_id,pk"`                  // Member
+	Role        string     `json:"role" pg:"role,notnull"`                   // One of OrgRoles
+	Status      string     `json:"status" pg:"status,default:'active'"`      // MembershipStatusActive or MembershipStatusSuspended
+	SuspendedAt *time.Time `json:"suspended_at,omitempty" pg:"suspended_at"` // Date and time the membership was suspended
+	CreatedAt   time.Time  `json:"created_at" pg:"created_at"`               // Date and time the user joined
+	UpdatedAt   time.Time  `json:"updated_at" pg:"updated_at"`               // Date and time of the last role or status change
+}
+
+// Active reports whether the membership gives access to the organization
+func (m *Membership) Active() bool {
+	return m.Status != MembershipStatusSuspended
+}
+
+// ValidOrgRole reports whether role is a membership role
+func ValidOrgRole(role string) bool {
+	for _, valid := range OrgRoles {
+		if role == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken represents an opaque refresh token issued to a user.
+// Only the SHA-256 hash of the token is stored. Every token belongs to a family
+// that starts at login; rotating a token adds a new member to the same family.
+type RefreshToken struct {
+	ID        string     `json:"id" pg:"id,pk"`                        // Primary key as UUID
+	UserID    string     `json:"user_id" pg:"user_id,notnull"`         // Owner of the token
+	FamilyID  string     `json:"family_id" pg:"family_id,notnull"`     // Rotation chain the token belongs to
+	ClientID  string     `json:"client_id,omitempty" pg:"client_id"`   // OAuth client the token was issued to, empty for first-party logins
+	Scope     string     `json:"scope,omitempty" pg:"scope"`           // Space-delimited scopes granted to the session
+	OrgID     string     `json:"org_id,omitempty" pg:"org_id"`         // Organization the session is scoped to
+	TokenHash string     `json:"-" pg:"token_hash,unique,notnull"`     // SHA-256 hash of the opaque token
+	ExpiresAt time.Time  `json:"expires_at" pg:"expires_at,notnull"`   // Date and time the token expires
+	UsedAt    *time.Time `json:"used_at,omitempty" pg:"used_at"`       // Set once the token has been rotated
+	RevokedAt *time.Time `json:"revoked_at,omitempty" pg:"revoked_at"` // Set when the token (or its family) is revoked
+	CreatedAt time.Time  `json:"created_at" pg:"created_at"`           // Date and time of issuance
+}
+
+// NewRefreshToken creates a refresh token record for the given user and family
+func NewRefreshToken(userID, familyID, tokenHash string, ttl time.Duration) *RefreshToken {
+	now := time.Now()
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+
+	return &RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// TableName sets the table name for the RefreshToken model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+)
+
+// Built-in permissions checked by the service's own routes. They are created at
+// startup together with the admin role, which grants all of them.
+const (
+	PermissionUsersRead   = "users:read"   // List and view users
+	PermissionUsersWrite  = "users:write"  // Update, deactivate and delete users
+	PermissionRolesManage = "roles:manage" // Manage roles and permissions and assign roles to users
+	PermissionAuditRead   = "audit:read"   // Read the audit trail
+
+	RoleAdmin = "admin"
+)
+
+// BuiltinPermissions lists the permissions created at startup
+var BuiltinPermissions = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionRolesManage,
+	PermissionAuditRead,
+}
+
+// Role is a named set of permissions assigned to users
+type Role struct {
+	tableName struct{} `pg:"roles"`
+
+	ID          string    `json:"id" pg:"id,pk"`                 // Primary key as UUID
+	Name        string    `json:"name" pg:"name,unique,notnull"` // Name embedded in access tokens, e.g. "admin"
+	Description string    `json:"description" pg:"description"`  // What the role is for
+	CreatedAt   time.Time `json:"created_at" pg:"created_at"`    // Date and time of creation
+	UpdatedAt   time.Time `json:"updated_at" pg:"updated_at"`    // Date and time of the last update
+}
+
+// Permission is an action that routes can require, e.g. "users:read"
+type Permission struct {
+	tableName struct{} `pg:"permissions"`
+
+	ID          string    `json:"id" pg:"id,pk"`                 // Primary key as UUID
+	Name        string    `json:"name" pg:"name,unique,notnull"` // Name embedded in access tokens
+	Description string    `json:"description" pg:"description"`  // What the permission allows
+	CreatedAt   time.Time `json:"created_at" pg:"created_at"`    // Date and time of creation
+}
+
+// RolePermission grants a permission to a role
+type RolePermission struct {
+	tableName struct{} `pg:"role_permissions"`
+
+	RoleID       string    `json:"role_id" pg:"role_id,pk"`             // Role granted the permission
+	PermissionID string    `json:"permission_id" pg:"permission_id,pk"` // Permission granted
+	CreatedAt    time.Time `json:"created_at" pg:"created_at"`          // Date and time of the grant
+}
+
+// UserRole assigns a role to a user
+type UserRole struct {
+	tableName struct{} `pg:"user_roles"`
+
+	UserID    string    `json:"user_id" pg:"user_id,pk"`    // User holding the role
+	RoleID    string    `json:"role_id" pg:"role_id,pk"`    // Role assigned
+	CreatedAt time.Time `json:"created_at" pg:"created_at"` // Date and time of the assignment
+}
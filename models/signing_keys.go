@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Signing key statuses
+const (
+	SigningKeyStatusPending = "pending" // Published for verification, not yet used for signing
+	SigningKeyStatusActive  = "active"  // Used to sign new tokens
+	SigningKeyStatusRetired = "retired" // Verification only, until VerifyUntil
+	SigningKeyStatusExpired = "expired" // No longer trusted
+)
+
+// SigningKey represents a JWT signing key stored in the database.
+// The private key is encrypted at rest (AES-256-GCM with KEY_ENCRYPTION_KEY).
+type SigningKey struct {
+	ID           string     `json:"kid" pg:"id,pk"`                           // Key ID, sent in the "kid" header
+	Algorithm    string     `json:"alg" pg:"algorithm,notnull"`               // RS256, ES256 or EdDSA
+	EncryptedKey string     `json:"-" pg:"encrypted_key,notnull"`             // Encrypted PKCS#8 PEM private key
+	Status       string     `json:"status" pg:"status,notnull"`               // pending, active, retired or expired
+	CreatedAt    time.Time  `json:"created_at" pg:"created_at"`               // Date and time the key was generated
+	ActivatedAt  *time.Time `json:"activated_at,omitempty" pg:"activated_at"` // Date and time the key started signing
+	RetiredAt    *time.Time `json:"retired_at,omitempty" pg:"retired_at"`     // Date and time the key stopped signing
+	VerifyUntil  *time.Time `json:"verify_until,omitempty" pg:"verify_until"` // Tokens signed with a retired key are accepted until then
+}
+
+// TableName sets the table name for the SigningKey model
+func (SigningKey) TableName() string {
+	return "signing_keys"
+}
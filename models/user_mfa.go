@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// UserMFA holds the second factors of a user. The TOTP secret is encrypted
+// (auth.EncryptSecret, bound to the user ID) and only the hashes of the unused
+// recovery codes are stored.
+type UserMFA struct {
+	tableName struct{} `pg:"user_mfa"`
+
+	UserID          string     `json:"user_id" pg:"user_id,pk"`                               // Owner of the factors
+	TOTPSecret      string     `json:"-" pg:"totp_secret"`                                    // Encrypted base32 TOTP secret
+	TOTPEnabled     bool       `json:"totp_enabled" pg:"totp_enabled,use_zero,default:false"` // Set once enrollment is confirmed with a valid code
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at,omitempty" pg:"totp_enabled_at"`        // Date and time enrollment was confirmed
+	TOTPLastCounter int64      `json:"-" pg:"totp_last_counter,use_zero,default:0"`           // Time step of the last accepted code, to reject replays
+	RecoveryCodes   []string   `json:"-" pg:"recovery_codes,array"`                           // Hashes of the unused recovery codes
+	CreatedAt       time.Time  `json:"created_at" pg:"created_at"`                            // Date and time enrollment started
+	UpdatedAt       time.Time  `json:"updated_at" pg:"updated_at"`                            // Date and time of the last update
+}
+
+// Methods lists the second factors the user can complete a login with, given
+// the number of WebAuthn credentials they registered. The user must present a
+// second factor when TOTP is enabled or a credential is registered; recovery
+// codes only stand in for one of those.
+func (m *UserMFA) Methods(webauthnCredentials int) []string {
+	methods := []string{}
+	if m.TOTPEnabled {
+		methods = append(methods, "totp")
+	}
+	if webauthnCredentials > 0 {
+		methods = append(methods, "webauthn")
+	}
+	if len(methods) > 0 && len(m.RecoveryCodes) > 0 {
+		methods = append(methods, "recovery_code")
+	}
+	return methods
+}
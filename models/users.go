@@ -3,38 +3,47 @@ package models
 import (
 	"time"
 
-	"github.com/drive-deep/auth-microservices/auth"
 	"github.com/google/uuid"
 )
 
+// Statuses of a user account. Deactivated users cannot log in and their tokens
+// are refused.
+const (
+	UserStatusActive      = "active"
+	UserStatusDeactivated = "deactivated"
+)
+
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id" pg:"id,pk"`              // Primary key as UUID
-	Email     string    `json:"email" pg:"email,unique"`    // Unique email
-	Password  string    `json:"password" pg:"password"`     // User password (hashed)
-	Salt      string    `json:"salt" pg:"salt"`             // Salt used for password hashing
-	FirstName string    `json:"first_name" pg:"first_name"` // User's first name
-	LastName  string    `json:"last_name" pg:"last_name"`   // User's last name
-	CreatedAt time.Time `json:"created_at" pg:"created_at"` // Date and time of user creation
-	UpdatedAt time.Time `json:"updated_at" pg:"updated_at"` // Date and time of the last update
+	ID              string     `json:"id" pg:"id,pk"`                                             // Primary key as UUID
+	Email           string     `json:"email" pg:"email,unique"`                                   // Unique email
+	Password        string     `json:"-" pg:"password"`                                           // User password (hashed)
+	Salt            string     `json:"-" pg:"salt"`                                               // Salt of legacy SHA-256 hashes, empty otherwise
+	FirstName       string     `json:"first_name" pg:"first_name"`                                // User's first name
+	LastName        string     `json:"last_name" pg:"last_name"`                                  // User's last name
+	EmailVerified   bool       `json:"email_verified" pg:"email_verified,use_zero,default:false"` // Whether the user proved ownership of the email
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" pg:"email_verified_at"`        // Date and time the email was verified
+	AuthzVersion    int64      `json:"authz_version" pg:"authz_version,use_zero,default:0"`       // Bumped whenever the user's roles or permissions change
+	Status          string     `json:"status" pg:"status,default:'active'"`                       // UserStatusActive or UserStatusDeactivated
+	DeactivatedAt   *time.Time `json:"deactivated_at,omitempty" pg:"deactivated_at"`              // Date and time the account was deactivated
+	CreatedAt       time.Time  `json:"created_at" pg:"created_at"`                                // Date and time of user creation
+	UpdatedAt       time.Time  `json:"updated_at" pg:"updated_at"`                                // Date and time of the last update
+}
+
+// Active reports whether the user may log in (rows created before account
+// statuses existed have none)
+func (u *User) Active() bool {
+	return u.Status != UserStatusDeactivated
 }
 
-// BeforeInsert hook to set default UUID and generate a salt if not set
+// BeforeInsert hook to set default UUID if not set. New users get a PHC-format
+// hash, which embeds its own salt, so the salt column stays empty.
 func (u *User) BeforeInsert() error {
 	if u.ID == "" {
 		newID := uuid.New().String()
 		u.ID = newID
 	}
 
-	// Generate a random salt if not already set
-	if u.Salt == "" {
-		salt, err := auth.GenerateSalt()
-		if err != nil {
-			return err
-		}
-		u.Salt = salt
-	}
-
 	return nil
 }
 
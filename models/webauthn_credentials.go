@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user. Only
+// the public key is stored; the signature counter is tracked to detect cloned
+// authenticators.
+type WebAuthnCredential struct {
+	tableName struct{} `pg:"webauthn_credentials"`
+
+	ID             string     `json:"id" pg:"id,pk"`                                               // Credential ID, base64url encoded
+	UserID         string     `json:"-" pg:"user_id,notnull"`                                      // Owner of the credential
+	Name           string     `json:"name" pg:"name"`                                              // Label chosen by the user
+	PublicKey      []byte     `json:"-" pg:"public_key,notnull"`                                   // COSE encoded public key
+	SignCount      int64      `json:"sign_count" pg:"sign_count,use_zero,default:0"`               // Last signature counter reported by the authenticator
+	AAGUID         string     `json:"aaguid" pg:"aaguid"`                                          // Authenticator model, all zeros without attestation
+	Transports     []string   `json:"transports,omitempty" pg:"transports,array"`                  // How the browser can reach the authenticator
+	BackupEligible bool       `json:"backup_eligible" pg:"backup_eligible,use_zero,default:false"` // Synced passkey rather than a device-bound key
+	CreatedAt      time.Time  `json:"created_at" pg:"created_at"`                                  // Date and time of registration
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty" pg:"last_used_at"`                    // Date and time of the last successful assertion
+}
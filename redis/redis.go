@@ -10,6 +10,9 @@ import (
 	"golang.org/x/net/context"
 )
 
+// ErrKeyNotFound is returned by Get when the key does not exist
+var ErrKeyNotFound = fmt.Errorf("key does not exist")
+
 type RedisClient struct {
 	client *redis.Client
 }
@@ -51,7 +54,18 @@ func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, ex
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
 	val, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key does not exist")
+		return "", ErrKeyNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("could not get value from redis: %v", err)
+	}
+	return val, nil
+}
+
+// GetDel atomically retrieves and removes a value, so it can only be consumed once
+func (r *RedisClient) GetDel(ctx context.Context, key string) (string, error) {
+	val, err := r.client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrKeyNotFound
 	} else if err != nil {
 		return "", fmt.Errorf("could not get value from redis: %v", err)
 	}
@@ -67,6 +81,63 @@ func (r *RedisClient) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// incrScript increments a counter and sets its expiry in one atomic step. A
+// counter found without expiry gets one too, so it can never outlive its window.
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Incr increments a counter and returns its new value. The counter expires
+// after ttl, counted from its first increment.
+func (r *RedisClient) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	count, err := incrScript.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("could not increment key in redis: %v", err)
+	}
+	return count, nil
+}
+
+// Exists reports whether a key exists in Redis
+func (r *RedisClient) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("could not check key in redis: %v", err)
+	}
+	return n > 0, nil
+}
+
+// TTL returns how long a key has left to live, or ErrKeyNotFound if it does
+// not exist. Keys without expiry report a negative duration.
+func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.TTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get ttl from redis: %v", err)
+	}
+	if ttl == -2 {
+		return 0, ErrKeyNotFound
+	}
+	return ttl, nil
+}
+
+// DeleteMatching removes every key matching a glob pattern. Keys are found
+// with SCAN, so Redis is not blocked on large databases.
+func (r *RedisClient) DeleteMatching(ctx context.Context, pattern string) error {
+	iter := r.client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if err := r.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return fmt.Errorf("could not delete key from redis: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("could not scan keys in redis: %v", err)
+	}
+	return nil
+}
+
 func (r *RedisClient) Reconnect(ctx context.Context) error {
 	maxRetries := 5
 	retryInterval := 2 * time.Second
@@ -117,6 +188,13 @@ func (r *RedisClient) AutoReconnect(ctx context.Context) {
 func InitRedis() (*RedisClient, error) {
 	// Retrieve Redis credentials from environment variables
 	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisPort := os.Getenv("REDIS_PORT")
+		if redisPort == "" {
+			redisPort = "6379"
+		}
+		redisAddr = fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), redisPort)
+	}
 	redisPassword := os.Getenv("REDIS_PASSWORD")
 	redisDB := 0 // Default to DB index 0, modify if needed
 
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupAdminRoutes sets up operator routes (signing keys, OAuth clients, account
+// lockout, roles and permissions, audit trail)
+func SetupAdminRoutes(app *fiber.App) {
+	admin := app.Group("/admin", middlewares.AdminTokenMiddleware())
+
+	// Signing key rotation
+	admin.Get("/keys", controllers.ListSigningKeys)
+	admin.Post("/keys", controllers.CreateSigningKey)
+	admin.Post("/keys/prune", controllers.PruneSigningKeys)
+	admin.Post("/keys/:kid/promote", controllers.PromoteSigningKey)
+
+	// OAuth client registration
+	admin.Get("/clients", controllers.ListClients)
+	admin.Post("/clients", controllers.CreateClient)
+	admin.Delete("/clients/:id", controllers.DeleteClient)
+
+	// User administration, the same routes users with users:read and users:write get
+	admin.Get("/users", controllers.GetUserDetails)
+	admin.Get("/users/:id", controllers.GetUser)
+	admin.Patch("/users/:id", controllers.UpdateUser)
+	admin.Delete("/users/:id", controllers.DeleteUser)
+
+	// Account lockout after failed logins
+	admin.Get("/users/:id/lockout", controllers.GetUserLockout)
+	admin.Post("/users/:id/unlock", controllers.UnlockUser)
+
+	// Roles and permissions, the same routes users with roles:manage get
+	registerRBACRoutes(admin)
+	admin.Get("/audit-log", controllers.ListAuditLog)
+}
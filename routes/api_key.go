@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupAPIKeyRoutes sets up routes for managing the logged-in user's personal
+// API keys. Keys are managed with access tokens only, an API key cannot create
+// other keys.
+func SetupAPIKeyRoutes(app *fiber.App) {
+	keys := app.Group("/api-keys",
+		middlewares.TokenAuthMiddleware(),
+		middlewares.RequireUser(),
+		middlewares.RequireFirstParty(),
+	)
+
+	keys.Get("/", controllers.ListAPIKeys)
+	keys.Post("/", controllers.CreateAPIKey)
+	keys.Delete("/:id", controllers.DeleteAPIKey)
+}
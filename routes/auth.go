@@ -2,14 +2,46 @@ package routes
 
 import (
 	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
 	"github.com/gofiber/fiber/v2"
 )
 
-// SetupAuthRoutes sets up routes related to authentication (signup, login).
+// SetupAuthRoutes sets up routes related to authentication (signup, login, logout).
 func SetupAuthRoutes(app *fiber.App) {
 	// POST route for user signup
 	app.Post("/signup", controllers.SignUp)
 
 	// POST route for user login
 	app.Post("/login", controllers.Login)
+
+	// POST route completing a login that returned mfa_required
+	app.Post("/login/mfa", controllers.VerifyMFA)
+
+	// POST route returning the WebAuthn options to answer an MFA challenge with a passkey
+	app.Post("/login/mfa/webauthn", controllers.BeginWebAuthnMFA)
+
+	// Passwordless login with a passkey: get the WebAuthn options, then send the assertion
+	app.Post("/login/webauthn/begin", controllers.BeginWebAuthnLogin)
+	app.Post("/login/webauthn/finish", controllers.FinishWebAuthnLogin)
+
+	// Passwordless login by email: request a single-use link or a 6-digit code,
+	// then exchange it for tokens
+	app.Post("/login/magic-link", controllers.RequestMagicLink)
+	app.Post("/login/magic-link/verify", controllers.VerifyMagicLink)
+	app.Post("/login/otp", controllers.RequestLoginOTP)
+	app.Post("/login/otp/verify", controllers.VerifyLoginOTP)
+
+	// Email verification: the link from the email (GET), the same token posted
+	// by a frontend, and requesting a new link
+	app.Get("/verify-email", controllers.VerifyEmail)
+	app.Post("/verify-email", controllers.VerifyEmail)
+	app.Post("/verify-email/resend", controllers.ResendVerification)
+
+	// Forgotten passwords: request a reset link, then set a new password with it
+	app.Post("/password/forgot", controllers.ForgotPassword)
+	app.Post("/password/reset", controllers.ResetPassword)
+
+	// POST routes for revoking the current session or every session of the user
+	app.Post("/logout", middlewares.TokenAuthMiddleware(), middlewares.RequireAccessToken(), controllers.Logout)
+	app.Post("/logout-all", middlewares.TokenAuthMiddleware(), middlewares.RequireAccessToken(), middlewares.RequireUser(), controllers.LogoutAll)
 }
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupMFARoutes sets up routes for managing the logged-in user's second factors
+func SetupMFARoutes(app *fiber.App) {
+	mfa := app.Group("/mfa", middlewares.TokenAuthMiddleware(), middlewares.RequireFirstParty())
+
+	// TOTP enrollment: get a secret/QR code, then confirm it with a code
+	mfa.Post("/totp/enroll", controllers.EnrollTOTP)
+	mfa.Post("/totp/confirm", controllers.ConfirmTOTP)
+	mfa.Delete("/totp", controllers.DisableTOTP)
+
+	// Passkeys and security keys: registration ceremony, listing and removal
+	mfa.Post("/webauthn/register/begin", controllers.BeginWebAuthnRegistration)
+	mfa.Post("/webauthn/register/finish", controllers.FinishWebAuthnRegistration)
+	mfa.Get("/webauthn/credentials", controllers.ListWebAuthnCredentials)
+	mfa.Delete("/webauthn/credentials/:id", controllers.DeleteWebAuthnCredential)
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupOAuthRoutes sets up the OAuth 2.0 and OpenID Connect endpoints
+func SetupOAuthRoutes(app *fiber.App) {
+	oauth := app.Group("/oauth")
+
+	// Authorization endpoint: login/consent page and its submission
+	oauth.Get("/authorize", controllers.Authorize)
+	oauth.Post("/authorize", controllers.AuthorizeDecision)
+
+	// Token endpoint: authorization_code (PKCE), refresh_token and client_credentials grants
+	oauth.Post("/token", controllers.Token)
+
+	// POST route for token introspection (RFC 7662), authenticated by client credentials
+	oauth.Post("/introspect", controllers.Introspect)
+
+	// OpenID Connect UserInfo endpoint (GET and POST per the spec)
+	app.Get("/userinfo", middlewares.TokenAuthMiddleware(), controllers.UserInfo)
+	app.Post("/userinfo", middlewares.TokenAuthMiddleware(), controllers.UserInfo)
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupOrgRoutes sets up routes for the logged-in user's organizations, for
+// managing the organization their token is scoped to, and for accepting
+// invitations
+func SetupOrgRoutes(app *fiber.App) {
+	orgs := app.Group("/orgs",
+		middlewares.TokenAuthMiddleware(),
+		middlewares.RequireUser(),
+		middlewares.RequireFirstParty(),
+	)
+
+	// Organizations of the user, and switching the tokens to another one
+	orgs.Get("/", controllers.ListOrganizations)
+	orgs.Post("/", controllers.CreateOrganization)
+	orgs.Post("/:id/switch", controllers.SwitchOrganization)
+
+	org := app.Group("/org",
+		middlewares.TokenAuthMiddleware(),
+		middlewares.RequireUser(),
+		middlewares.RequireFirstParty(),
+		middlewares.RequireOrg(),
+	)
+	manage := middlewares.RequireOrgRole(models.OrgRoleOwner, models.OrgRoleAdmin)
+
+	// Current organization and its members (members can also leave on their own)
+	org.Get("/", controllers.GetCurrentOrganization)
+	org.Patch("/", manage, controllers.UpdateCurrentOrganization)
+	org.Get("/members", controllers.ListOrgMembers)
+	org.Patch("/members/:user_id", manage, controllers.UpdateOrgMember)
+	org.Delete("/members/:user_id", controllers.RemoveOrgMember)
+
+	// Invitations to the current organization
+	org.Get("/invitations", manage, controllers.ListInvitations)
+	org.Post("/invitations", manage, controllers.CreateInvitation)
+	org.Post("/invitations/:id/resend", manage, controllers.ResendInvitation)
+	org.Delete("/invitations/:id", manage, controllers.RevokeInvitation)
+
+	// Invite links: details for the invite page, and acceptance (joining or signing up)
+	app.Post("/invitations/details", controllers.GetInvitationDetails)
+	app.Post("/invitations/accept", controllers.AcceptInvitation)
+}
@@ -8,11 +8,19 @@ import (
 // ProtectedDataRoute defines the route for fetching protected data
 func ProtectedDataRoute(app *fiber.App) {
 	// Define the protected route with the middleware
-	app.Get("/protected/secure-data", middlewares.TokenAuthMiddleware(), func(c *fiber.Ctx) error {
+	app.Get("/protected/secure-data", middlewares.TokenAuthMiddleware(), middlewares.RequireVerifiedEmail(), func(c *fiber.Ctx) error {
 		// Retrieve user info from the context set by the middleware
 		userID := c.Locals("user_id")
 		email := c.Locals("email")
 
+		// Service tokens have no user, report the calling client instead
+		if middlewares.IsService(c) {
+			return c.JSON(fiber.Map{
+				"message":   "This is protected data",
+				"client_id": c.Locals("client_id"),
+			})
+		}
+
 		// Return protected data
 		return c.JSON(fiber.Map{
 			"message": "This is protected data",
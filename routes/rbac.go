@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/drive-deep/auth-microservices/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupRBACRoutes sets up routes for managing roles and permissions, for users
+// holding the roles:manage permission, and for reading the audit trail
+func SetupRBACRoutes(app *fiber.App) {
+	registerRBACRoutes(app,
+		middlewares.TokenAuthMiddleware(),
+		middlewares.RequireFirstParty(),
+		middlewares.RequirePermission(models.PermissionRolesManage),
+	)
+
+	app.Get("/audit-log",
+		middlewares.TokenAuthMiddleware(),
+		middlewares.RequireFirstParty(),
+		middlewares.RequirePermission(models.PermissionAuditRead),
+		controllers.ListAuditLog,
+	)
+}
+
+// registerRBACRoutes registers the role and permission management routes on
+// the router, each behind the guard handlers. They are served both to users
+// with the roles:manage permission and under /admin with the admin API token,
+// which is how the first administrators are assigned.
+func registerRBACRoutes(router fiber.Router, guard ...fiber.Handler) {
+	h := func(handler fiber.Handler) []fiber.Handler {
+		return append(append([]fiber.Handler{}, guard...), handler)
+	}
+
+	// Roles and the permissions they grant (roles and permissions are referenced by ID or name)
+	router.Get("/roles", h(controllers.ListRoles)...)
+	router.Post("/roles", h(controllers.CreateRole)...)
+	router.Get("/roles/:id", h(controllers.GetRole)...)
+	router.Patch("/roles/:id", h(controllers.UpdateRole)...)
+	router.Delete("/roles/:id", h(controllers.DeleteRole)...)
+	router.Put("/roles/:id/permissions/:permission", h(controllers.AddRolePermission)...)
+	router.Delete("/roles/:id/permissions/:permission", h(controllers.RemoveRolePermission)...)
+
+	// Permissions
+	router.Get("/permissions", h(controllers.ListPermissions)...)
+	router.Post("/permissions", h(controllers.CreatePermission)...)
+	router.Delete("/permissions/:id", h(controllers.DeletePermission)...)
+
+	// Role assignment and effective permissions of users
+	router.Get("/users/:id/roles", h(controllers.ListUserRoles)...)
+	router.Put("/users/:id/roles/:role", h(controllers.AssignUserRole)...)
+	router.Delete("/users/:id/roles/:role", h(controllers.UnassignUserRole)...)
+	router.Get("/users/:id/permissions", h(controllers.GetUserPermissions)...)
+}
@@ -16,4 +16,25 @@ func SetupRoutes(app *fiber.App) {
 
 	// Setup refresh token route
 	RefreshTokenRoute(app) // Add this line to register the refresh route
+
+	// Setup second factor routes
+	SetupMFARoutes(app)
+
+	// Setup OAuth 2.0 routes
+	SetupOAuthRoutes(app)
+
+	// Setup discovery routes (JWKS)
+	SetupWellKnownRoutes(app)
+
+	// Setup role and permission management routes
+	SetupRBACRoutes(app)
+
+	// Setup organization routes
+	SetupOrgRoutes(app)
+
+	// Setup personal API key routes
+	SetupAPIKeyRoutes(app)
+
+	// Setup operator routes
+	SetupAdminRoutes(app)
 }
@@ -2,11 +2,26 @@ package routes
 
 import (
 	"github.com/drive-deep/auth-microservices/controllers"
+	middlewares "github.com/drive-deep/auth-microservices/middleware"
+	"github.com/drive-deep/auth-microservices/models"
 	"github.com/gofiber/fiber/v2"
 )
 
 // SetupUserRoutes sets up routes related to user operations (e.g., fetch user details).
 func SetupUserRoutes(app *fiber.App) {
-	// GET route for fetching user details
-	app.Get("/users", controllers.GetUserDetails)
+	// GET route for fetching user details, for callers allowed to read users
+	app.Get("/users", middlewares.TokenAuthMiddleware(), middlewares.RequirePermission(models.PermissionUsersRead), controllers.GetUserDetails)
+
+	// User administration: view for callers allowed to read users, update,
+	// deactivate and delete for those allowed to write them
+	app.Get("/users/:id", middlewares.TokenAuthMiddleware(), middlewares.RequirePermission(models.PermissionUsersRead), controllers.GetUser)
+	app.Patch("/users/:id", middlewares.TokenAuthMiddleware(), middlewares.RequireFirstParty(), middlewares.RequirePermission(models.PermissionUsersWrite), controllers.UpdateUser)
+	app.Delete("/users/:id", middlewares.TokenAuthMiddleware(), middlewares.RequireFirstParty(), middlewares.RequirePermission(models.PermissionUsersWrite), controllers.DeleteUser)
+
+	// Profile of the logged-in user
+	app.Get("/me", middlewares.TokenAuthMiddleware(), middlewares.RequireUser(), controllers.GetMe)
+	app.Patch("/me", middlewares.TokenAuthMiddleware(), middlewares.RequireUser(), middlewares.RequireFirstParty(), controllers.UpdateMe)
+
+	// PUT route for changing the logged-in user's password
+	app.Put("/me/password", middlewares.TokenAuthMiddleware(), middlewares.RequireFirstParty(), controllers.ChangePassword)
 }
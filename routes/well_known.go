@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/drive-deep/auth-microservices/controllers"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupWellKnownRoutes sets up the discovery routes under /.well-known
+func SetupWellKnownRoutes(app *fiber.App) {
+	// GET route for the JSON Web Key Set
+	app.Get("/.well-known/jwks.json", controllers.JWKS)
+
+	// GET route for the OpenID Connect discovery document
+	app.Get("/.well-known/openid-configuration", controllers.OpenIDConfiguration)
+}
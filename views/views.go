@@ -0,0 +1,18 @@
+package views
+
+import (
+	"embed"
+	"html/template"
+	"io"
+)
+
+//go:embed *.html
+var files embed.FS
+
+// templates holds the server-rendered pages
+var templates = template.Must(template.ParseFS(files, "*.html"))
+
+// Render executes the named page template with the given data
+func Render(w io.Writer, name string, data interface{}) error {
+	return templates.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,103 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE_Key labels and values (RFC 9052, RFC 9053)
+const (
+	coseKeyType = 1
+	coseKeyAlg  = 3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// publicKey is a credential public key decoded from its COSE_Key
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, rejecting key types and algorithms that are not supported
+func parsePublicKey(data []byte) (*publicKey, error) {
+	var fields map[int]interface{}
+	if err := cbor.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%w: invalid public key: %v", ErrVerification, err)
+	}
+
+	kty, _ := coseInt(fields[coseKeyType])
+	alg, _ := coseInt(fields[coseKeyAlg])
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := coseInt(fields[-1])
+		x, _ := fields[-2].([]byte)
+		y, _ := fields[-3].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid EC2 public key", ErrVerification)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("%w: EC2 public key is not on the curve", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: key}, nil
+
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := fields[-1].([]byte)
+		e, _ := fields[-2].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA public key", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := coseInt(fields[-1])
+		x, _ := fields[-2].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid OKP public key", ErrVerification)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported public key type %d with algorithm %d", ErrVerification, kty, alg)
+}
+
+// verify checks sig over data
+func (k *publicKey) verify(data, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	}
+	return false
+}
+
+// coseInt converts a CBOR integer, decoded as int64 or uint64, to an int
+func coseInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	}
+	return 0, false
+}
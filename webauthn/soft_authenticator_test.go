@@ -0,0 +1,173 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborEncMode encodes CBOR deterministically (RFC 8949 core deterministic encoding)
+var cborEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+// errNoCredential is returned by softAuthenticator when it holds no usable credential
+var errNoCredential = errors.New("no matching credential")
+
+// softAuthenticator is an in-memory ES256 authenticator acting as a browser
+// and platform authenticator together. It lets the registration and login
+// flows be exercised without a browser or a security key.
+type softAuthenticator struct {
+	Origin string
+
+	// SkipUserVerification makes the authenticator only assert user
+	// presence, like a security key without a PIN
+	SkipUserVerification bool
+
+	mu          sync.Mutex
+	credentials []*softCredential
+}
+
+type softCredential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+	flags      byte
+}
+
+// newSoftAuthenticator creates an authenticator for ceremonies run from origin
+func newSoftAuthenticator(origin string) *softAuthenticator {
+	return &softAuthenticator{Origin: origin}
+}
+
+// Register creates a discoverable credential for the options and returns the
+// response to send back to the relying party
+func (a *softAuthenticator) Register(opts *CreationOptions) (*RegistrationResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := encodeES256PublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := byte(flagUserPresent | flagUserVerified)
+	if a.SkipUserVerification {
+		flags = flagUserPresent
+	}
+	credential := &softCredential{id: id, rpID: opts.RP.ID, userHandle: opts.User.ID, key: key, flags: flags}
+	authData := credential.authenticatorData(flagAttestedCredData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(authData, id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := cborEncMode.Marshal(attestationObject{Fmt: "none", AttStmt: []byte{0xa0}, AuthData: authData})
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.credentials = append(a.credentials, credential)
+	a.mu.Unlock()
+
+	resp := &RegistrationResponse{ID: base64.RawURLEncoding.EncodeToString(id), RawID: id, Type: "public-key"}
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", opts.Challenge)
+	resp.Response.AttestationObject = attestation
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Assert signs the challenge of the options with the first credential of the
+// relying party that is allowed, incrementing its signature counter
+func (a *softAuthenticator) Assert(opts *RequestOptions) (*AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	credential := a.find(opts)
+	if credential == nil {
+		return nil, errNoCredential
+	}
+	credential.signCount++
+
+	authData := credential.authenticatorData(0)
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, credential.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &AssertionResponse{ID: base64.RawURLEncoding.EncodeToString(credential.id), RawID: credential.id, Type: "public-key"}
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = credential.userHandle
+	return resp, nil
+}
+
+func (a *softAuthenticator) find(opts *RequestOptions) *softCredential {
+	for _, credential := range a.credentials {
+		if credential.rpID != opts.RPID {
+			continue
+		}
+		if len(opts.AllowCredentials) == 0 {
+			return credential
+		}
+		for _, allowed := range opts.AllowCredentials {
+			if string(allowed.ID) == string(credential.id) {
+				return credential
+			}
+		}
+	}
+	return nil
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.Origin,
+	})
+	return data
+}
+
+// authenticatorData returns the RP ID hash, flags and counter; user presence
+// is always asserted, user verification unless SkipUserVerification was set
+func (c *softCredential) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags|c.flags)
+	return binary.BigEndian.AppendUint32(data, c.signCount)
+}
+
+// encodeES256PublicKey encodes a P-256 public key as a COSE_Key
+func encodeES256PublicKey(key *ecdsa.PublicKey) ([]byte, error) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return cborEncMode.Marshal(map[int]interface{}{
+		coseKeyType: coseKeyTypeEC2,
+		coseKeyAlg:  AlgES256,
+		-1:          coseCurveP256,
+		-2:          x,
+		-3:          y,
+	})
+}
@@ -0,0 +1,226 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ErrSignCount is returned when the signature counter of an authenticator did
+// not increase, which suggests the credential was cloned
+var ErrSignCount = errors.New("webauthn signature counter did not increase")
+
+// Authenticator data flags
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagBackupEligible   = 0x08
+	flagBackupState      = 0x10
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+)
+
+// clientData is the collected client data signed by the authenticator
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// attestationObject is the CBOR structure returned on registration
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// authenticatorData is the parsed authenticator data of a ceremony
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// Assertion is the result of a verified authentication ceremony
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	UserHandle   []byte
+}
+
+// VerifyRegistration verifies the response to a registration ceremony started
+// with the given challenge and returns the new credential. The attestation
+// statement is not verified, matching the "none" conveyance preference.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, resp *RegistrationResponse, requireUV bool) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("%w: invalid credential type", ErrVerification)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var attestation attestationObject
+	if err := cbor.Unmarshal(resp.Response.AttestationObject, &attestation); err != nil {
+		return nil, fmt.Errorf("%w: invalid attestation object: %v", ErrVerification, err)
+	}
+
+	authData, err := parseAuthenticatorData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if authData.Flags&flagAttestedCredData == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+	if !bytes.Equal(authData.CredentialID, resp.RawID) {
+		return nil, fmt.Errorf("%w: credential ID mismatch", ErrVerification)
+	}
+	if _, err := parsePublicKey(authData.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             authData.CredentialID,
+		PublicKey:      authData.PublicKey,
+		SignCount:      authData.SignCount,
+		AAGUID:         authData.AAGUID,
+		Transports:     resp.Response.Transports,
+		UserVerified:   authData.Flags&flagUserVerified != 0,
+		BackupEligible: authData.Flags&flagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion verifies the response to an authentication ceremony started
+// with the given challenge against a stored credential public key and
+// signature counter
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, resp *AssertionResponse, credentialPublicKey []byte, signCount uint32, requireUV bool) (*Assertion, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("%w: invalid credential type", ErrVerification)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Response.Signature) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	// Authenticators that do not implement a counter always report zero
+	if (authData.SignCount != 0 || signCount != 0) && authData.SignCount <= signCount {
+		return nil, ErrSignCount
+	}
+
+	return &Assertion{
+		SignCount:    authData.SignCount,
+		UserVerified: authData.Flags&flagUserVerified != 0,
+		UserHandle:   resp.Response.UserHandle,
+	}, nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin of the client data
+func (rp *RelyingParty) verifyClientData(data []byte, ceremony string, challenge []byte) error {
+	var client clientData
+	if err := json.Unmarshal(data, &client); err != nil {
+		return fmt.Errorf("%w: invalid client data: %v", ErrVerification, err)
+	}
+	if client.Type != ceremony {
+		return fmt.Errorf("%w: unexpected client data type %q", ErrVerification, client.Type)
+	}
+
+	received, err := base64.RawURLEncoding.DecodeString(client.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+
+	for _, origin := range rp.Origins {
+		if client.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %q is not allowed", ErrVerification, client.Origin)
+}
+
+// verifyAuthenticatorData checks the RP ID hash and the user presence and verification flags
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: RP ID mismatch", ErrVerification)
+	}
+	if authData.Flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrVerification)
+	}
+	if requireUV && authData.Flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrVerification)
+	}
+	if authData.Flags&flagBackupState != 0 && authData.Flags&flagBackupEligible == 0 {
+		return fmt.Errorf("%w: invalid backup flags", ErrVerification)
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses authenticator data, including the attested
+// credential data when present. Extension data is ignored.
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrVerification)
+	}
+
+	authData := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.Flags&flagAttestedCredData == 0 {
+		return authData, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data too short", ErrVerification)
+	}
+	authData.AAGUID = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+		return nil, fmt.Errorf("%w: invalid credential ID length", ErrVerification)
+	}
+	authData.CredentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	// The COSE key is followed by the optional extensions, so its length is
+	// only known once decoded
+	decoder := cbor.NewDecoder(bytes.NewReader(rest))
+	var key cbor.RawMessage
+	if err := decoder.Decode(&key); err != nil {
+		return nil, fmt.Errorf("%w: invalid credential public key: %v", ErrVerification, err)
+	}
+	authData.PublicKey = key
+	return authData, nil
+}
@@ -0,0 +1,298 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const testOrigin = "https://login.example.com"
+
+func testRelyingParty() *RelyingParty {
+	return &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{testOrigin}}
+}
+
+var testUser = User{ID: []byte("user-1"), Name: "jane@example.com", DisplayName: "Jane"}
+
+// roundTrip sends v through JSON like a frontend does, so the tests also cover
+// the base64url encoding of binary fields
+func roundTrip(t *testing.T, v, out interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+}
+
+// register runs a registration ceremony between rp and the authenticator
+func register(t *testing.T, rp *RelyingParty, authenticator *softAuthenticator, requireUV bool) (*Credential, error) {
+	t.Helper()
+	opts, err := rp.NewCreationOptions(testUser, nil)
+	if err != nil {
+		t.Fatalf("NewCreationOptions: %v", err)
+	}
+
+	var sent CreationOptions
+	roundTrip(t, opts, &sent)
+	resp, err := authenticator.Register(&sent)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var received RegistrationResponse
+	roundTrip(t, resp, &received)
+	return rp.VerifyRegistration(opts.Challenge, &received, requireUV)
+}
+
+// assert runs an authentication ceremony for the credential and verifies it
+// against the stored signature counter
+func assert(t *testing.T, rp *RelyingParty, authenticator *softAuthenticator, credential *Credential, signCount uint32, requireUV bool) (*Assertion, error) {
+	t.Helper()
+	opts, err := rp.NewRequestOptions([]CredentialDescriptor{{Type: "public-key", ID: credential.ID}}, UserVerificationPreferred)
+	if err != nil {
+		t.Fatalf("NewRequestOptions: %v", err)
+	}
+
+	var sent RequestOptions
+	roundTrip(t, opts, &sent)
+	resp, err := authenticator.Assert(&sent)
+	if err != nil {
+		t.Fatalf("Assert: %v", err)
+	}
+
+	var received AssertionResponse
+	roundTrip(t, resp, &received)
+	return rp.VerifyAssertion(opts.Challenge, &received, credential.PublicKey, signCount, requireUV)
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := testRelyingParty()
+	authenticator := newSoftAuthenticator(testOrigin)
+
+	credential, err := register(t, rp, authenticator, true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if !credential.UserVerified || credential.SignCount != 0 || len(credential.ID) == 0 {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+
+	signCount := credential.SignCount
+	for i := 1; i <= 2; i++ {
+		assertion, err := assert(t, rp, authenticator, credential, signCount, true)
+		if err != nil {
+			t.Fatalf("VerifyAssertion #%d: %v", i, err)
+		}
+		if assertion.SignCount != uint32(i) {
+			t.Errorf("SignCount = %d, want %d", assertion.SignCount, i)
+		}
+		if string(assertion.UserHandle) != string(testUser.ID) {
+			t.Errorf("UserHandle = %q, want %q", assertion.UserHandle, testUser.ID)
+		}
+		signCount = assertion.SignCount
+	}
+}
+
+func TestAssertionSignCountRegression(t *testing.T) {
+	rp := testRelyingParty()
+	authenticator := newSoftAuthenticator(testOrigin)
+
+	credential, err := register(t, rp, authenticator, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	// The authenticator reports 1, but a clone already reached 5
+	if _, err := assert(t, rp, authenticator, credential, 5, false); !errors.Is(err, ErrSignCount) {
+		t.Errorf("err = %v, want ErrSignCount", err)
+	}
+	// Replaying the stored counter is refused too
+	if _, err := assert(t, rp, authenticator, credential, 2, false); !errors.Is(err, ErrSignCount) {
+		t.Errorf("err = %v, want ErrSignCount", err)
+	}
+}
+
+func TestAssertionWrongKey(t *testing.T) {
+	rp := testRelyingParty()
+	authenticator := newSoftAuthenticator(testOrigin)
+
+	credential, err := register(t, rp, authenticator, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	other, err := register(t, rp, newSoftAuthenticator(testOrigin), false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	// Signed by the first credential, checked against the second one's key
+	credential.PublicKey = other.PublicKey
+	if _, err := assert(t, rp, authenticator, credential, 0, false); !errors.Is(err, ErrVerification) {
+		t.Errorf("err = %v, want ErrVerification", err)
+	}
+}
+
+func TestRegistrationMismatches(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator *softAuthenticator
+		rpID          string // RP ID the authenticator is asked for, if not the relying party's
+	}{
+		{"origin", newSoftAuthenticator("https://evil.example.net"), ""},
+		{"rp id", newSoftAuthenticator(testOrigin), "evil.example.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := testRelyingParty()
+			opts, err := rp.NewCreationOptions(testUser, nil)
+			if err != nil {
+				t.Fatalf("NewCreationOptions: %v", err)
+			}
+			if tt.rpID != "" {
+				opts.RP.ID = tt.rpID
+			}
+
+			resp, err := tt.authenticator.Register(opts)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if _, err := rp.VerifyRegistration(opts.Challenge, resp, false); !errors.Is(err, ErrVerification) {
+				t.Errorf("err = %v, want ErrVerification", err)
+			}
+		})
+	}
+
+	t.Run("challenge", func(t *testing.T) {
+		rp := testRelyingParty()
+		opts, err := rp.NewCreationOptions(testUser, nil)
+		if err != nil {
+			t.Fatalf("NewCreationOptions: %v", err)
+		}
+		resp, err := newSoftAuthenticator(testOrigin).Register(opts)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		other, _ := NewChallenge()
+		if _, err := rp.VerifyRegistration(other, resp, false); !errors.Is(err, ErrVerification) {
+			t.Errorf("err = %v, want ErrVerification", err)
+		}
+	})
+}
+
+func TestAssertionMismatches(t *testing.T) {
+	rp := testRelyingParty()
+	authenticator := newSoftAuthenticator(testOrigin)
+	credential, err := register(t, rp, authenticator, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	newOptions := func(t *testing.T) *RequestOptions {
+		opts, err := rp.NewRequestOptions(nil, UserVerificationPreferred)
+		if err != nil {
+			t.Fatalf("NewRequestOptions: %v", err)
+		}
+		return opts
+	}
+
+	t.Run("origin", func(t *testing.T) {
+		opts := newOptions(t)
+		authenticator.Origin = "https://evil.example.net"
+		defer func() { authenticator.Origin = testOrigin }()
+
+		resp, err := authenticator.Assert(opts)
+		if err != nil {
+			t.Fatalf("Assert: %v", err)
+		}
+		if _, err := rp.VerifyAssertion(opts.Challenge, resp, credential.PublicKey, 0, false); !errors.Is(err, ErrVerification) {
+			t.Errorf("err = %v, want ErrVerification", err)
+		}
+	})
+
+	t.Run("rp id", func(t *testing.T) {
+		// A credential of another site, answering options that name that site
+		evil := &RelyingParty{ID: "evil.example.net", Origins: []string{testOrigin}}
+		evilCredential, err := register(t, evil, authenticator, false)
+		if err != nil {
+			t.Fatalf("VerifyRegistration: %v", err)
+		}
+
+		opts := newOptions(t)
+		opts.RPID = evil.ID
+		resp, err := authenticator.Assert(opts)
+		if err != nil {
+			t.Fatalf("Assert: %v", err)
+		}
+		if _, err := rp.VerifyAssertion(opts.Challenge, resp, evilCredential.PublicKey, 0, false); !errors.Is(err, ErrVerification) {
+			t.Errorf("err = %v, want ErrVerification", err)
+		}
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		opts := newOptions(t)
+		resp, err := authenticator.Assert(opts)
+		if err != nil {
+			t.Fatalf("Assert: %v", err)
+		}
+
+		other, _ := NewChallenge()
+		if _, err := rp.VerifyAssertion(other, resp, credential.PublicKey, 0, false); !errors.Is(err, ErrVerification) {
+			t.Errorf("err = %v, want ErrVerification", err)
+		}
+	})
+
+	t.Run("ceremony", func(t *testing.T) {
+		// Client data of a registration cannot be used to log in
+		opts, err := rp.NewCreationOptions(testUser, nil)
+		if err != nil {
+			t.Fatalf("NewCreationOptions: %v", err)
+		}
+		registration, err := authenticator.Register(opts)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		resp, err := authenticator.Assert(newOptions(t))
+		if err != nil {
+			t.Fatalf("Assert: %v", err)
+		}
+		resp.Response.ClientDataJSON = registration.Response.ClientDataJSON
+		if _, err := rp.VerifyAssertion(opts.Challenge, resp, credential.PublicKey, 0, false); !errors.Is(err, ErrVerification) {
+			t.Errorf("err = %v, want ErrVerification", err)
+		}
+	})
+}
+
+func TestUserVerificationRequired(t *testing.T) {
+	rp := testRelyingParty()
+	authenticator := newSoftAuthenticator(testOrigin)
+	authenticator.SkipUserVerification = true
+
+	if _, err := register(t, rp, authenticator, true); !errors.Is(err, ErrVerification) {
+		t.Errorf("registration err = %v, want ErrVerification", err)
+	}
+
+	credential, err := register(t, rp, authenticator, false)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if credential.UserVerified {
+		t.Error("credential registered without user verification reports UserVerified")
+	}
+
+	if _, err := assert(t, rp, authenticator, credential, 0, true); !errors.Is(err, ErrVerification) {
+		t.Errorf("assertion err = %v, want ErrVerification", err)
+	}
+	assertion, err := assert(t, rp, authenticator, credential, 0, false)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if assertion.UserVerified {
+		t.Error("assertion without user verification reports UserVerified")
+	}
+}